@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// The agent started by "hs9001 unlock" keeps the key of a passphrase
+// encrypted database in memory and hands it out over a Unix socket, which
+// only the user can access. Clients send "key" to receive the hex encoded
+// key and "lock" to stop the agent.
+
+// socketLocation returns the path of a Unix socket only the user can access.
+// The directory in /tmp is created if necessary; another user could have
+// created it first, so it is only used if it is private to the user.
+func socketLocation(name string) (string, error) {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), fmt.Sprintf("hs9001-%d", os.Getuid()))
+		err := os.Mkdir(dir, 0700)
+		if err != nil && !os.IsExist(err) {
+			return "", err
+		}
+	}
+	if err := checkPrivateDir(dir); err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name), nil
+}
+
+// checkPrivateDir makes sure dir is a directory (not a symlink) owned by the
+// user, which nobody else can access.
+func checkPrivateDir(dir string) error {
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !info.IsDir() || !ok || int(stat.Uid) != os.Getuid() || info.Mode().Perm()&0077 != 0 {
+		return fmt.Errorf("refusing to use %s, it has to be a directory owned by you with mode 0700", dir)
+	}
+	return nil
+}
+
+// listenUnix creates the socket at path, replacing a stale one.
+func listenUnix(path string) (net.Listener, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return nil, err
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("%s is in use by another process", path)
+	}
+	os.Remove(path)
+	oldMask := syscall.Umask(0077)
+	defer syscall.Umask(oldMask)
+	return net.Listen("unix", path)
+}
+
+func agentSocketLocation() (string, error) {
+	return socketLocation("hs9001-agent.sock")
+}
+
+func agentRequest(request string) (string, error) {
+	path, err := agentSocketLocation()
+	if err != nil {
+		return "", err
+	}
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+
+	_, err = fmt.Fprintf(conn, "%s\n", request)
+	if err != nil {
+		return "", err
+	}
+	response, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(response), nil
+}
+
+func agentKey() ([]byte, error) {
+	response, err := agentRequest("key")
+	if err != nil {
+		return nil, err
+	}
+	return hex.DecodeString(response)
+}
+
+// queueLocation is where "hs9001 add" keeps the entries recorded while the
+// database is locked. Like the key, they stay in the private runtime directory.
+func queueLocation() (string, error) {
+	return socketLocation("hs9001-queue.jsonl")
+}
+
+func queueEntry(entry HistoryEntry) error {
+	path, err := queueLocation()
+	if err != nil {
+		return err
+	}
+	line, err := json.Marshal(newWireEntry(&entry))
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(line, '\n'))
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// addQueued adds the entries queued while the database was locked. The queue
+// is moved away first, so concurrent calls do not add them twice.
+func addQueued(conn *sql.DB) (int, error) {
+	path, err := queueLocation()
+	if err != nil {
+		return 0, err
+	}
+	claimed := fmt.Sprintf("%s.%d", path, os.Getpid())
+	err = os.Rename(path, claimed)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	f, err := os.Open(claimed)
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(claimed)
+	defer f.Close()
+
+	count := 0
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		var w wireEntry
+		if err := json.Unmarshal(scanner.Bytes(), &w); err != nil {
+			return count, err
+		}
+		add(conn, w.entry())
+		count++
+	}
+	return count, scanner.Err()
+}
+
+func lockAgent() error {
+	_, err := agentRequest("lock")
+	return err
+}
+
+// startAgent runs "hs9001 agent" in the background, the key is passed on
+// stdin so it does not show up in the process list.
+func startAgent(key []byte, timeout time.Duration) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	fmt.Fprintf(w, "%s\n", hex.EncodeToString(key))
+	w.Close()
+
+	cmd := exec.Command(executable, "agent", "-timeout", timeout.String())
+	cmd.Stdin = r
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	err = cmd.Start()
+	if err != nil {
+		return err
+	}
+	cmd.Process.Release()
+
+	// Wait until the agent is ready to serve the key
+	for i := 0; i < 50; i++ {
+		if _, err = agentKey(); err == nil {
+			return nil
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return fmt.Errorf("agent did not start: %s", err.Error())
+}
+
+func runAgent(timeout time.Duration) error {
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return err
+	}
+	key := strings.TrimSpace(line)
+	if _, err := hex.DecodeString(key); err != nil || key == "" {
+		return errors.New("invalid key")
+	}
+
+	path, err := agentSocketLocation()
+	if err != nil {
+		return err
+	}
+	listener, err := listenUnix(path)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		select {
+		case <-stop:
+		case <-time.After(timeout):
+		}
+		listener.Close()
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			// The listener has been closed
+			return nil
+		}
+		conn.SetDeadline(time.Now().Add(time.Second))
+		request, _ := bufio.NewReader(conn).ReadString('\n')
+		switch strings.TrimSpace(request) {
+		case "key":
+			fmt.Fprintf(conn, "%s\n", key)
+		case "lock":
+			fmt.Fprintf(conn, "ok\n")
+			conn.Close()
+			return nil
+		}
+		conn.Close()
+	}
+}
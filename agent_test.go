@@ -0,0 +1,73 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckPrivateDir(t *testing.T) {
+	base := t.TempDir()
+	private := filepath.Join(base, "private")
+	shared := filepath.Join(base, "shared")
+	link := filepath.Join(base, "link")
+	file := filepath.Join(base, "file")
+	if err := os.Mkdir(private, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(shared, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(shared, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(private, link); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		dir string
+		ok  bool
+	}{
+		{private, true},
+		{shared, false},
+		{link, false},
+		{file, false},
+		{filepath.Join(base, "missing"), false},
+	}
+	for _, test := range tests {
+		if err := checkPrivateDir(test.dir); (err == nil) != test.ok {
+			t.Errorf("checkPrivateDir(%s) = %v, want ok = %v", test.dir, err, test.ok)
+		}
+	}
+}
+
+func TestAddQueued(t *testing.T) {
+	runtimeDir := filepath.Join(t.TempDir(), "run")
+	if err := os.Mkdir(runtimeDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("XDG_RUNTIME_DIR", runtimeDir)
+	conn := newTestDatabase(t, "db.sqlite")
+
+	for _, cmd := range []string{"make", "make test", "make"} {
+		if err := queueEntry(NewHistoryEntry(cmd, 0)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	count, err := addQueued(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 || countCommand(conn, "make") != 2 || countCommand(conn, "make test") != 1 {
+		t.Errorf("addQueued added %d entries, want 'make' twice and 'make test' once", count)
+	}
+
+	count, err = addQueued(conn)
+	if err != nil || count != 0 {
+		t.Errorf("addQueued added %d entries again (%v), want none", count, err)
+	}
+}
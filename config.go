@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"hs9001/liner"
+)
+
+// Config holds the settings read from the config file. The file uses an INI
+// like syntax with [sections] and "key = value" lines; keys which take a
+// list of values may be repeated.
+type Config struct {
+	dbPath string
+
+	searchFlags    []string
+	searchLimit    int
+	searchTemplate string
+
+	colors      string // auto, always or never
+	failedColor string // SGR parameters used for failed commands
+
+	ctrlrMode     int
+	ctrlrLimit    int
+	ctrlrListRows int
+	ctrlrRanking  string // recent or frecency, see rankFrecency
+
+	ignore []string // rules in the form "<kind> <argument>", see parseIgnoreRules
+	redact []string
+
+	keyfile string // key of an encrypted database, see rekey
+}
+
+var config = defaultConfig()
+
+func defaultConfig() Config {
+	return Config{
+		dbPath:        filepath.Join(xdgOrFallback("XDG_DATA_HOME", filepath.Join(os.Getenv("HOME"), ".local/share")), "hs9001/db.sqlite"),
+		colors:        "auto",
+		failedColor:   "38;5;88",
+		ctrlrMode:     liner.ModeGlobal,
+		ctrlrLimit:    100,
+		ctrlrListRows: 10,
+		ctrlrRanking:  "frecency",
+	}
+}
+
+func configLocation() string {
+	return filepath.Join(xdgOrFallback("XDG_CONFIG_HOME", filepath.Join(os.Getenv("HOME"), ".config")), "hs9001")
+}
+
+func configFileLocation() string {
+	return filepath.Join(configLocation(), "config")
+}
+
+func unquote(value string) string {
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
+
+func parseConfigBool(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "true", "yes", "on", "1":
+		return true, nil
+	case "false", "no", "off", "0":
+		return false, nil
+	}
+	return false, fmt.Errorf("'%s' is not a boolean", value)
+}
+
+func (c *Config) set(section string, key string, value string) error {
+	var err error
+	switch section + "." + key {
+	case "database.path":
+		c.dbPath = value
+	case "search.flags":
+		c.searchFlags = strings.Fields(value)
+	case "search.limit":
+		c.searchLimit, err = strconv.Atoi(value)
+	case "search.template":
+		c.searchTemplate = value
+	case "colors.mode":
+		switch value {
+		case "auto", "always", "never":
+			c.colors = value
+		default:
+			err = fmt.Errorf("must be one of auto, always or never")
+		}
+	case "colors.failed":
+		c.failedColor = value
+	case "reverse-search.mode":
+		switch value {
+		case "global":
+			c.ctrlrMode = liner.ModeGlobal
+		case "workdir":
+			c.ctrlrMode = liner.ModeWorkdir
+		default:
+			err = fmt.Errorf("must be either global or workdir")
+		}
+	case "reverse-search.limit":
+		var limit int
+		limit, err = strconv.Atoi(value)
+		if err == nil && limit < 1 {
+			err = fmt.Errorf("must be at least 1")
+		}
+		if err == nil {
+			c.ctrlrLimit = limit
+		}
+	case "reverse-search.list-rows":
+		var rows int
+		rows, err = strconv.Atoi(value)
+		if err == nil && rows < 0 {
+			err = fmt.Errorf("must not be negative")
+		}
+		if err == nil {
+			c.ctrlrListRows = rows
+		}
+	case "reverse-search.ranking":
+		switch value {
+		case "recent", "frecency":
+			c.ctrlrRanking = value
+		default:
+			err = fmt.Errorf("must be either recent or frecency")
+		}
+	case "ignore.ignorespace":
+		var ignoreSpace bool
+		ignoreSpace, err = parseConfigBool(value)
+		if ignoreSpace {
+			c.ignore = append(c.ignore, "ignorespace")
+		}
+	case "ignore.glob", "ignore.regex", "ignore.dir":
+		c.ignore = append(c.ignore, key+" "+value)
+	case "redact.rule":
+		c.redact = append(c.redact, value)
+	case "encryption.keyfile":
+		c.keyfile = value
+	default:
+		err = fmt.Errorf("unknown setting")
+	}
+	return err
+}
+
+// loadConfig reads the config file, if there is one, on top of the default
+// settings. HS9001_DB_PATH still takes precedence over the database path.
+func loadConfig() Config {
+	c := defaultConfig()
+
+	f, err := os.Open(configFileLocation())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "Failed to read config file: %s\n", err.Error())
+		}
+	} else {
+		defer f.Close()
+
+		section := ""
+		lineNumber := 0
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			lineNumber++
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+				continue
+			}
+			if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+				section = strings.TrimSpace(line[1 : len(line)-1])
+				continue
+			}
+			eq := strings.Index(line, "=")
+			if eq < 0 {
+				fmt.Fprintf(os.Stderr, "%s:%d: Expected 'key = value'\n", configFileLocation(), lineNumber)
+				continue
+			}
+			key := strings.TrimSpace(line[:eq])
+			value := unquote(strings.TrimSpace(line[eq+1:]))
+			if err := c.set(section, key, value); err != nil {
+				fmt.Fprintf(os.Stderr, "%s:%d: Ignoring %s.%s: %s\n", configFileLocation(), lineNumber, section, key, err.Error())
+			}
+		}
+	}
+
+	envOverride := os.Getenv("HS9001_DB_PATH")
+	if envOverride != "" {
+		c.dbPath = envOverride
+	}
+	return c
+}
+
+// printColors returns whether output to f should be colored.
+func (c *Config) printColors(f *os.File) bool {
+	switch c.colors {
+	case "always":
+		return true
+	case "never":
+		return false
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		panic(err)
+	}
+	//Don't print colors if output is piped
+	return (fi.Mode() & os.ModeCharDevice) != 0
+}
+
+// print writes the effective configuration in the format of the config file.
+func (c *Config) print() {
+	fmt.Printf("# Config file: %s\n", configFileLocation())
+	fmt.Printf("[database]\n")
+	fmt.Printf("path = %s\n", c.dbPath)
+	fmt.Printf("\n[search]\n")
+	fmt.Printf("flags = %s\n", strings.Join(c.searchFlags, " "))
+	fmt.Printf("limit = %d\n", c.searchLimit)
+	fmt.Printf("template = %s\n", c.searchTemplate)
+	fmt.Printf("\n[colors]\n")
+	fmt.Printf("mode = %s\n", c.colors)
+	fmt.Printf("failed = %s\n", c.failedColor)
+	fmt.Printf("\n[reverse-search]\n")
+	mode := "global"
+	if c.ctrlrMode == liner.ModeWorkdir {
+		mode = "workdir"
+	}
+	fmt.Printf("mode = %s\n", mode)
+	fmt.Printf("limit = %d\n", c.ctrlrLimit)
+	fmt.Printf("list-rows = %d\n", c.ctrlrListRows)
+	fmt.Printf("ranking = %s\n", c.ctrlrRanking)
+	fmt.Printf("\n[ignore]\n")
+	for _, rule := range c.ignore {
+		if rule == "ignorespace" {
+			fmt.Printf("ignorespace = true\n")
+			continue
+		}
+		kind := strings.Fields(rule)[0]
+		fmt.Printf("%s = %s\n", kind, strings.TrimSpace(strings.TrimPrefix(rule, kind)))
+	}
+	fmt.Printf("\n[redact]\n")
+	for _, rule := range c.redact {
+		fmt.Printf("rule = %s\n", rule)
+	}
+	fmt.Printf("\n[encryption]\n")
+	fmt.Printf("keyfile = %s\n", c.keyfile)
+}
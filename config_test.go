@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+func TestConfigSetReverseSearch(t *testing.T) {
+	tests := []struct {
+		key      string
+		value    string
+		ok       bool
+		limit    int
+		listRows int
+	}{
+		{"limit", "50", true, 50, 10},
+		{"limit", "0", false, 100, 10},
+		{"limit", "-5", false, 100, 10},
+		{"limit", "many", false, 100, 10},
+		{"list-rows", "0", true, 100, 0},
+		{"list-rows", "-1", false, 100, 10},
+	}
+	for _, test := range tests {
+		c := defaultConfig()
+		err := c.set("reverse-search", test.key, test.value)
+		if (err == nil) != test.ok {
+			t.Errorf("set(%s = %s): error = %v, want ok = %v", test.key, test.value, err, test.ok)
+		}
+		if c.ctrlrLimit != test.limit || c.ctrlrListRows != test.listRows {
+			t.Errorf("set(%s = %s): limit = %d, list-rows = %d, want %d and %d", test.key, test.value, c.ctrlrLimit, c.ctrlrListRows, test.limit, test.listRows)
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+
+	"hs9001/liner"
+)
+
+// Prefix of encrypted column values, anything else is stored in plain text
+const sealedPrefix = "enc1:"
+
+// Known value encrypted with the key, so a wrong key is noticed
+const keyCheckValue = "hs9001"
+
+const pbkdf2Iterations = 600000
+
+// errLocked is returned by loadEncryption if the key of a passphrase
+// encrypted database is not available, as the agent is not running.
+var errLocked = errors.New("the database is locked")
+
+// encryption is set if the command and workdir columns of the database are
+// encrypted, see loadEncryption.
+var encryption *sealer
+
+// sealer encrypts column values with AES-GCM. The nonce is derived from the
+// value, so equal values have equal ciphertexts. This reveals which entries
+// share a command or directory, but keeps equality checks in SQL working,
+// e.g. for searches by directory and duplicate detection during imports.
+type sealer struct {
+	aead     cipher.AEAD
+	nonceKey []byte
+}
+
+func deriveKey(key []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+func newSealer(key []byte) *sealer {
+	block, err := aes.NewCipher(deriveKey(key, "hs9001 encryption"))
+	if err != nil {
+		log.Panic(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		log.Panic(err)
+	}
+	return &sealer{aead: aead, nonceKey: deriveKey(key, "hs9001 nonce")}
+}
+
+func (s *sealer) seal(value string) string {
+	if value == "" {
+		return value
+	}
+	nonce := deriveKey(s.nonceKey, value)[:s.aead.NonceSize()]
+	sealed := s.aead.Seal(nonce, nonce, []byte(value), nil)
+	return sealedPrefix + base64.RawStdEncoding.EncodeToString(sealed)
+}
+
+func (s *sealer) open(value string) (string, error) {
+	if !strings.HasPrefix(value, sealedPrefix) {
+		return value, nil
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(value, sealedPrefix))
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < s.aead.NonceSize() {
+		return "", errors.New("ciphertext too short")
+	}
+	nonce := sealed[:s.aead.NonceSize()]
+	plain, err := s.aead.Open(nil, nonce, sealed[len(nonce):], nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+// sealColumn encrypts a command or workdir before it is written to the
+// database, if encryption is enabled.
+func sealColumn(value string) string {
+	if encryption == nil {
+		return value
+	}
+	return encryption.seal(value)
+}
+
+// openColumn decrypts a command or workdir read from the database.
+func openColumn(value string) string {
+	if encryption == nil {
+		return value
+	}
+	plain, err := encryption.open(value)
+	if err != nil {
+		log.Panic(err)
+	}
+	return plain
+}
+
+// pbkdf2 derives a 32 byte key from a passphrase as specified in RFC 8018,
+// with HMAC-SHA256 as pseudorandom function.
+func pbkdf2(passphrase []byte, salt []byte, iterations int) []byte {
+	prf := hmac.New(sha256.New, passphrase)
+	prf.Write(salt)
+	prf.Write([]byte{0, 0, 0, 1})
+	u := prf.Sum(nil)
+	key := make([]byte, len(u))
+	copy(key, u)
+	for i := 1; i < iterations; i++ {
+		prf.Reset()
+		prf.Write(u)
+		u = prf.Sum(u[:0])
+		for j := range key {
+			key[j] ^= u[j]
+		}
+	}
+	return key
+}
+
+func passphraseKey(conn *sql.DB, passphrase string) []byte {
+	salt, err := base64.RawStdEncoding.DecodeString(getSetting(conn, "kdf_salt"))
+	if err != nil {
+		log.Panic(err)
+	}
+	iterations := pbkdf2Iterations
+	if stored := getSetting(conn, "kdf_iterations"); stored != "" {
+		fmt.Sscan(stored, &iterations)
+	}
+	return pbkdf2([]byte(passphrase), salt, iterations)
+}
+
+func keyfileKey(path string) ([]byte, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(strings.TrimSpace(string(content))) == 0 {
+		return nil, fmt.Errorf("%s is empty", path)
+	}
+	key := sha256.Sum256([]byte(strings.TrimSpace(string(content))))
+	return key[:], nil
+}
+
+// checkKey returns whether key is the one the database is encrypted with.
+func checkKey(conn *sql.DB, key []byte) bool {
+	plain, err := newSealer(key).open(getSetting(conn, "key_check"))
+	return err == nil && plain == keyCheckValue
+}
+
+// loadEncryption fetches the key of an encrypted database from the keyfile or
+// the agent started by "hs9001 unlock".
+func loadEncryption(conn *sql.DB) error {
+	var key []byte
+	var err error
+	switch getSetting(conn, "key_source") {
+	case "":
+		// Not encrypted
+		return nil
+	case "keyfile":
+		if config.keyfile == "" {
+			return errors.New("the database is encrypted, but no keyfile is set in the [encryption] section of the config file")
+		}
+		key, err = keyfileKey(config.keyfile)
+	case "passphrase":
+		key, err = agentKey()
+		if err != nil {
+			return fmt.Errorf("%w, run 'hs9001 unlock' (%s)", errLocked, err.Error())
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if !checkKey(conn, key) {
+		return errors.New("wrong key for the encrypted database")
+	}
+	encryption = newSealer(key)
+	return nil
+}
+
+// rekey re-encrypts the command and workdir columns with a new key. keySource
+// is either "keyfile" or "passphrase", the salt is only used for the latter.
+// A nil key decrypts the database.
+func rekey(conn *sql.DB, key []byte, keySource string, salt []byte) int {
+	results := search(conn, searchopts{})
+
+	_, err := conn.Exec("BEGIN;")
+	if err != nil {
+		log.Panic(err)
+	}
+
+	encryption = nil
+	if key != nil {
+		encryption = newSealer(key)
+	}
+
+	count := 0
+	for e := results.Front(); e != nil; e = e.Next() {
+		entry, ok := e.Value.(*HistoryEntry)
+		if !ok {
+			log.Panic("Failed to retrieve entries")
+		}
+		_, err = conn.Exec("UPDATE history SET command = ?, workdir = ? WHERE id = ?", sealColumn(entry.cmd), sealColumn(entry.cwd), entry.id)
+		if err != nil {
+			log.Panic(err)
+		}
+		count++
+	}
+
+	if key == nil {
+		setSetting(conn, "key_source", "")
+		setSetting(conn, "key_check", "")
+		setSetting(conn, "kdf_salt", "")
+	} else {
+		setSetting(conn, "key_source", keySource)
+		setSetting(conn, "key_check", encryption.seal(keyCheckValue))
+		setSetting(conn, "kdf_salt", base64.RawStdEncoding.EncodeToString(salt))
+		setSetting(conn, "kdf_iterations", fmt.Sprint(pbkdf2Iterations))
+	}
+
+	_, err = conn.Exec("END;")
+	if err != nil {
+		log.Panic(err)
+	}
+
+	// Remove the old values from the full-text index and unused pages
+	_, err = conn.Exec("INSERT INTO history_fts(history_fts) VALUES ('rebuild')")
+	if err != nil {
+		log.Panic(err)
+	}
+	_, err = conn.Exec("VACUUM")
+	if err != nil {
+		log.Panic(err)
+	}
+	return count
+}
+
+func newSalt() []byte {
+	salt := make([]byte, 16)
+	_, err := rand.Read(salt)
+	if err != nil {
+		log.Panic(err)
+	}
+	return salt
+}
+
+// readPassphrase prompts for a passphrase, a new one has to be entered twice.
+func readPassphrase(prompt string, confirm bool) (string, error) {
+	line := liner.NewLiner()
+	defer line.Close()
+	line.SetCtrlCAborts(true)
+
+	passphrase, err := line.PasswordPrompt(prompt)
+	if err != nil {
+		return "", err
+	}
+	if passphrase == "" {
+		return "", errors.New("empty passphrase")
+	}
+	if confirm {
+		repeated, err := line.PasswordPrompt("Repeat " + strings.ToLower(prompt))
+		if err != nil {
+			return "", err
+		}
+		if repeated != passphrase {
+			return "", errors.New("the passphrases do not match")
+		}
+	}
+	return passphrase, nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestPbkdf2(t *testing.T) {
+	// PBKDF2-HMAC-SHA256 test vectors of RFC 7914, section 11, of which the
+	// first 32 bytes are derived
+	tests := []struct {
+		passphrase string
+		salt       string
+		iterations int
+		want       string
+	}{
+		{"passwd", "salt", 1, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc"},
+		{"Password", "NaCl", 80000, "4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56"},
+	}
+	for _, test := range tests {
+		got := hex.EncodeToString(pbkdf2([]byte(test.passphrase), []byte(test.salt), test.iterations))
+		if got != test.want {
+			t.Errorf("pbkdf2(%q, %q, %d) = %s, want %s", test.passphrase, test.salt, test.iterations, got, test.want)
+		}
+	}
+}
+
+func TestSealer(t *testing.T) {
+	s := newSealer([]byte("key"))
+	for _, value := range []string{"ls -la", "/home/me", "echo тут\n日本"} {
+		sealed := s.seal(value)
+		if !strings.HasPrefix(sealed, sealedPrefix) || strings.Contains(sealed, value) {
+			t.Errorf("seal(%q) = %q, which is not encrypted", value, sealed)
+		}
+		if again := s.seal(value); again != sealed {
+			t.Errorf("seal(%q) is not deterministic: %q and %q", value, sealed, again)
+		}
+		if plain, err := s.open(sealed); err != nil || plain != value {
+			t.Errorf("open(seal(%q)) = %q, %v", value, plain, err)
+		}
+		if _, err := newSealer([]byte("other key")).open(sealed); err == nil {
+			t.Errorf("%q could be decrypted with the wrong key", value)
+		}
+	}
+	if sealed := s.seal(""); sealed != "" {
+		t.Errorf("seal(\"\") = %q, want it to stay empty", sealed)
+	}
+	// Entries from before the database was encrypted
+	if plain, err := s.open("ls"); err != nil || plain != "ls" {
+		t.Errorf("open(\"ls\") = %q, %v, want it unchanged", plain, err)
+	}
+}
+
+// storedCommands returns the command column as it is stored in the database.
+func storedCommands(conn *sql.DB) []string {
+	rows, err := conn.Query("SELECT command FROM history ORDER BY id")
+	if err != nil {
+		log.Panic(err)
+	}
+	defer rows.Close()
+	var commands []string
+	for rows.Next() {
+		var cmd string
+		if err := rows.Scan(&cmd); err != nil {
+			log.Panic(err)
+		}
+		commands = append(commands, cmd)
+	}
+	return commands
+}
+
+func TestRekey(t *testing.T) {
+	t.Cleanup(func() { encryption = nil })
+	conn := newTestDatabase(t, "db.sqlite")
+	add(conn, NewHistoryEntry("git push", 0))
+	add(conn, NewHistoryEntry("make", 0))
+
+	key := []byte("0123456789abcdef0123456789abcdef")
+	if count := rekey(conn, key, "keyfile", nil); count != 2 {
+		t.Errorf("rekey rewrote %d entries, want 2", count)
+	}
+	for _, cmd := range storedCommands(conn) {
+		if !strings.HasPrefix(cmd, sealedPrefix) {
+			t.Errorf("%q is stored unencrypted", cmd)
+		}
+	}
+	if !checkKey(conn, key) || checkKey(conn, []byte("wrong")) {
+		t.Error("checkKey does not recognize the key")
+	}
+	if countCommand(conn, "make") != 1 {
+		t.Error("the encrypted entry is not found")
+	}
+
+	// rekey -decrypt
+	rekey(conn, nil, "", nil)
+	if encryption != nil || getSetting(conn, "key_source") != "" || getSetting(conn, "key_check") != "" {
+		t.Error("the database is still marked as encrypted")
+	}
+	if got := strings.Join(storedCommands(conn), ","); got != "git push,make" {
+		t.Errorf("the decrypted database contains %s", got)
+	}
+}
+
+func TestSyncEncrypted(t *testing.T) {
+	t.Cleanup(func() { encryption = nil })
+	key := []byte("0123456789abcdef0123456789abcdef")
+	plain := newTestDatabase(t, "plain.sqlite")
+	add(plain, NewHistoryEntry("git pull", 0))
+	encrypted := newTestDatabase(t, "encrypted.sqlite")
+	add(encrypted, NewHistoryEntry("git push", 0))
+	rekey(encrypted, key, "keyfile", nil)
+
+	if _, _, err := syncDatabases(encrypted, plain); err == nil {
+		t.Error("an encrypted database was synced with a plain one")
+	}
+	if _, _, err := syncDatabases(plain, encrypted); err == nil {
+		t.Error("a plain database was synced with an encrypted one")
+	}
+	if got := strings.Join(storedCommands(plain), ","); got != "git pull" {
+		t.Errorf("the refused sync changed the plain database to %s", got)
+	}
+
+	// An empty database takes over the encryption
+	empty := newTestDatabase(t, "empty.sqlite")
+	if _, pushed, err := syncDatabases(encrypted, empty); err != nil || pushed != 1 {
+		t.Fatalf("sync into an empty database pushed %d entries: %v", pushed, err)
+	}
+	if !checkKey(empty, key) {
+		t.Error("the empty database did not take over the key")
+	}
+	for _, cmd := range storedCommands(empty) {
+		if !strings.HasPrefix(cmd, sealedPrefix) {
+			t.Errorf("%q is stored unencrypted", cmd)
+		}
+	}
+	if countCommand(empty, "git push") != 1 {
+		t.Error("the synced entry is not found")
+	}
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"bufio"
+	"container/list"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"regexp"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// The daemon keeps the database open and accepts requests on a Unix socket,
+// one JSON object per line, so "add" and "bash-ctrlr" do not have to open and
+// migrate the database each time. New entries are written in batches.
+
+const daemonBatchSize = 100
+
+func daemonSocketLocation() (string, error) {
+	return socketLocation("hs9001-daemon.sock")
+}
+
+type wireEntry struct {
+	Id       uint32 `json:"id"`
+	Time     int64  `json:"time"`
+	Command  string `json:"command"`
+	Workdir  string `json:"workdir"`
+	Hostname string `json:"hostname"`
+	User     string `json:"user"`
+	Retval   int    `json:"retval"`
+	Duration int    `json:"duration"`
+	Session  string `json:"session"`
+	Source   string `json:"source"`
+	Uuid     string `json:"uuid"`
+}
+
+func newWireEntry(entry *HistoryEntry) wireEntry {
+	return wireEntry{
+		Id:       entry.id,
+		Time:     entry.timestamp.Unix(),
+		Command:  entry.cmd,
+		Workdir:  entry.cwd,
+		Hostname: entry.hostname,
+		User:     entry.user,
+		Retval:   entry.retval,
+		Duration: entry.duration,
+		Session:  entry.session,
+		Source:   entry.source,
+		Uuid:     entry.uuid,
+	}
+}
+
+func (w *wireEntry) entry() HistoryEntry {
+	return HistoryEntry{
+		id:        w.Id,
+		cmd:       w.Command,
+		cwd:       w.Workdir,
+		hostname:  w.Hostname,
+		user:      w.User,
+		retval:    w.Retval,
+		timestamp: time.Unix(w.Time, 0),
+		duration:  w.Duration,
+		session:   w.Session,
+		source:    w.Source,
+		uuid:      w.Uuid,
+	}
+}
+
+type wireSearch struct {
+	Command     *string `json:"command,omitempty"`
+	Substring   *string `json:"substring,omitempty"`
+	Match       *string `json:"match,omitempty"`
+	Regex       string  `json:"regex,omitempty"`
+	Workdir     *string `json:"workdir,omitempty"`
+	After       *int64  `json:"after,omitempty"`
+	Before      *int64  `json:"before,omitempty"`
+	Retval      *int    `json:"retval,omitempty"`
+	MinDuration *int    `json:"min_duration,omitempty"`
+	MaxDuration *int    `json:"max_duration,omitempty"`
+	Session     *string `json:"session,omitempty"`
+	Source      *string `json:"source,omitempty"`
+	Uuid        *string `json:"uuid,omitempty"`
+	Hostname    *string `json:"hostname,omitempty"`
+	Failed      bool    `json:"failed,omitempty"`
+	Order       *string `json:"order,omitempty"`
+	Limit       *int    `json:"limit,omitempty"`
+	Offset      *int    `json:"offset,omitempty"`
+}
+
+func newWireSearch(opts searchopts) wireSearch {
+	w := wireSearch{
+		Command:     opts.command,
+		Substring:   opts.substring,
+		Match:       opts.match,
+		Workdir:     opts.workdir,
+		Retval:      opts.retval,
+		MinDuration: opts.minDuration,
+		MaxDuration: opts.maxDuration,
+		Session:     opts.session,
+		Source:      opts.source,
+		Uuid:        opts.uuid,
+		Hostname:    opts.hostname,
+		Failed:      opts.failed,
+		Order:       opts.order,
+		Limit:       opts.limit,
+		Offset:      opts.offset,
+	}
+	if opts.regex != nil {
+		w.Regex = opts.regex.String()
+	}
+	if opts.after != nil {
+		after := opts.after.Unix()
+		w.After = &after
+	}
+	if opts.before != nil {
+		before := opts.before.Unix()
+		w.Before = &before
+	}
+	return w
+}
+
+func (w *wireSearch) searchopts() (searchopts, error) {
+	opts := searchopts{
+		command:     w.Command,
+		substring:   w.Substring,
+		match:       w.Match,
+		workdir:     w.Workdir,
+		retval:      w.Retval,
+		minDuration: w.MinDuration,
+		maxDuration: w.MaxDuration,
+		session:     w.Session,
+		source:      w.Source,
+		uuid:        w.Uuid,
+		hostname:    w.Hostname,
+		failed:      w.Failed,
+		order:       w.Order,
+		limit:       w.Limit,
+		offset:      w.Offset,
+	}
+	if w.Regex != "" {
+		rgx, err := regexp.Compile(w.Regex)
+		if err != nil {
+			return opts, err
+		}
+		opts.regex = rgx
+	}
+	if w.After != nil {
+		after := time.Unix(*w.After, 0)
+		opts.after = &after
+	}
+	if w.Before != nil {
+		before := time.Unix(*w.Before, 0)
+		opts.before = &before
+	}
+	if opts.order != nil && *opts.order != "ASC" && *opts.order != "DESC" {
+		return opts, errors.New("invalid order")
+	}
+	return opts, nil
+}
+
+type daemonRequest struct {
+	Op     string      `json:"op"` // add, search or flush
+	Entry  *wireEntry  `json:"entry,omitempty"`
+	Search *wireSearch `json:"search,omitempty"`
+}
+
+type daemonResponse struct {
+	Error   string      `json:"error,omitempty"`
+	Entries []wireEntry `json:"entries,omitempty"`
+}
+
+// daemonClient is a connection to a running daemon.
+type daemonClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// dialDaemon connects to the daemon, an error means it is not running.
+func dialDaemon() (*daemonClient, error) {
+	path, err := daemonSocketLocation()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialTimeout("unix", path, 100*time.Millisecond)
+	if err != nil {
+		return nil, err
+	}
+	return &daemonClient{conn: conn, r: bufio.NewReader(conn)}, nil
+}
+
+func (c *daemonClient) Close() error {
+	return c.conn.Close()
+}
+
+func (c *daemonClient) request(req daemonRequest) (daemonResponse, error) {
+	var resp daemonResponse
+	c.conn.SetDeadline(time.Now().Add(5 * time.Second))
+	data, err := json.Marshal(req)
+	if err != nil {
+		return resp, err
+	}
+	_, err = c.conn.Write(append(data, '\n'))
+	if err != nil {
+		return resp, err
+	}
+	line, err := c.r.ReadBytes('\n')
+	if err != nil {
+		return resp, err
+	}
+	err = json.Unmarshal(line, &resp)
+	if err != nil {
+		return resp, err
+	}
+	if resp.Error != "" {
+		return resp, errors.New(resp.Error)
+	}
+	return resp, nil
+}
+
+func (c *daemonClient) add(entry HistoryEntry) error {
+	w := newWireEntry(&entry)
+	_, err := c.request(daemonRequest{Op: "add", Entry: &w})
+	return err
+}
+
+func (c *daemonClient) flush() error {
+	_, err := c.request(daemonRequest{Op: "flush"})
+	return err
+}
+
+// flushDaemon makes a running daemon write its pending entries, so they are
+// seen by commands reading the database directly.
+func flushDaemon() {
+	client, err := dialDaemon()
+	if err != nil {
+		return
+	}
+	defer client.Close()
+	client.flush()
+}
+
+func (c *daemonClient) search(opts searchopts) (list.List, error) {
+	var result list.List
+	w := newWireSearch(opts)
+	resp, err := c.request(daemonRequest{Op: "search", Search: &w})
+	if err != nil {
+		return result, err
+	}
+	for _, w := range resp.Entries {
+		entry := w.entry()
+		result.PushBack(&entry)
+	}
+	return result, nil
+}
+
+type daemon struct {
+	conn *sql.DB
+	// mu serializes all access to the database, as transactions are started
+	// with "BEGIN;" on the connection pool
+	mu      sync.Mutex
+	pending []HistoryEntry
+}
+
+// flush writes the pending entries, the caller must hold d.mu.
+func (d *daemon) flush() {
+	if len(d.pending) == 0 {
+		return
+	}
+	_, err := d.conn.Exec("BEGIN;")
+	if err != nil {
+		log.Panic(err)
+	}
+	stmt := prepareAdd(d.conn)
+	for _, entry := range d.pending {
+		addPrepared(stmt, entry)
+	}
+	stmt.Close()
+	_, err = d.conn.Exec("END;")
+	if err != nil {
+		log.Panic(err)
+	}
+	d.pending = nil
+}
+
+func (d *daemon) handle(req daemonRequest) daemonResponse {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	switch req.Op {
+	case "add":
+		if req.Entry == nil {
+			return daemonResponse{Error: "missing entry"}
+		}
+		d.pending = append(d.pending, req.Entry.entry())
+		if len(d.pending) >= daemonBatchSize {
+			d.flush()
+		}
+		return daemonResponse{}
+	case "flush":
+		d.flush()
+		return daemonResponse{}
+	case "search":
+		if req.Search == nil {
+			return daemonResponse{Error: "missing search"}
+		}
+		opts, err := req.Search.searchopts()
+		if err != nil {
+			return daemonResponse{Error: err.Error()}
+		}
+		if opts.match != nil && encryption != nil {
+			return daemonResponse{Error: "full-text queries are not supported for encrypted databases"}
+		}
+		if opts.match != nil {
+			if err := checkMatch(d.conn, *opts.match); err != nil {
+				return daemonResponse{Error: err.Error()}
+			}
+		}
+		// Searches have to find the commands which have just been added
+		d.flush()
+		var resp daemonResponse
+		results := search(d.conn, opts)
+		for e := results.Front(); e != nil; e = e.Next() {
+			entry, ok := e.Value.(*HistoryEntry)
+			if !ok {
+				log.Panic("Failed to retrieve entries")
+			}
+			resp.Entries = append(resp.Entries, newWireEntry(entry))
+		}
+		return resp
+	}
+	return daemonResponse{Error: "unknown op '" + req.Op + "'"}
+}
+
+func (d *daemon) serve(c net.Conn) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			return
+		}
+		var req daemonRequest
+		var resp daemonResponse
+		if err := json.Unmarshal(line, &req); err != nil {
+			resp = daemonResponse{Error: err.Error()}
+		} else {
+			resp = d.handle(req)
+		}
+		data, err := json.Marshal(resp)
+		if err != nil {
+			log.Panic(err)
+		}
+		_, err = c.Write(append(data, '\n'))
+		if err != nil {
+			return
+		}
+	}
+}
+
+// runDaemon serves requests until it is terminated, pending entries are
+// written at least every flushInterval.
+func runDaemon(conn *sql.DB, flushInterval time.Duration) error {
+	path, err := daemonSocketLocation()
+	if err != nil {
+		return err
+	}
+	listener, err := listenUnix(path)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+
+	d := &daemon{conn: conn}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	stopped := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(flushInterval)
+		for {
+			select {
+			case <-ticker.C:
+				d.mu.Lock()
+				d.flush()
+				d.mu.Unlock()
+			case <-stop:
+				// Write what is left, the lock is kept so no more
+				// requests are handled
+				d.mu.Lock()
+				d.flush()
+				close(stopped)
+				listener.Close()
+				return
+			}
+		}
+	}()
+
+	for {
+		c, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-stopped:
+				return nil
+			default:
+				return err
+			}
+		}
+		go d.serve(c)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bufio"
+	"container/list"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+)
+
+var exportFormats = []string{"bash", "zsh", "fish"}
+
+// startTime returns the unix timestamp at which entry has been started, as far
+// as it is known.
+func startTime(entry *HistoryEntry) int64 {
+	ts := entry.timestamp.Unix()
+	if ts <= 0 {
+		return 0
+	}
+	if entry.duration > 0 {
+		return ts - int64(entry.duration)
+	}
+	return ts
+}
+
+// metafy encodes a command the way zsh writes its history file, see
+// unmetafy: NUL and the bytes zsh uses internally (0x83 to 0xa2) are written
+// as 0x83 followed by the byte XOR 32.
+func metafy(cmd []byte) []byte {
+	result := make([]byte, 0, len(cmd))
+	for _, b := range cmd {
+		if b == 0 || (b >= 0x83 && b <= 0xa2) {
+			result = append(result, 0x83, b^32)
+		} else {
+			result = append(result, b)
+		}
+	}
+	return result
+}
+
+// exportHistory writes results in the native history file format of a shell:
+// bash with HISTTIMEFORMAT set, zsh's EXTENDED_HISTORY or fish_history.
+func exportHistory(w io.Writer, format string, results list.List) error {
+	known := false
+	for _, f := range exportFormats {
+		known = known || f == format
+	}
+	if !known {
+		return fmt.Errorf("unknown format '%s', available formats: %s", format, strings.Join(exportFormats, ", "))
+	}
+
+	bw := bufio.NewWriter(w)
+	for e := results.Front(); e != nil; e = e.Next() {
+		entry, ok := e.Value.(*HistoryEntry)
+		if !ok {
+			log.Panic("Failed to retrieve entries")
+		}
+		switch format {
+		case "bash":
+			// Entries imported without a timestamp are exported without one
+			if entry.timestamp.Unix() > 0 {
+				fmt.Fprintf(bw, "#%d\n", entry.timestamp.Unix())
+			}
+			fmt.Fprintf(bw, "%s\n", entry.cmd)
+		case "zsh":
+			elapsed := entry.duration
+			if elapsed < 0 {
+				elapsed = 0
+			}
+			// zsh continues multi-line commands with a trailing backslash
+			cmd := strings.ReplaceAll(entry.cmd, "\n", "\\\n")
+			fmt.Fprintf(bw, ": %d:%d;%s\n", startTime(entry), elapsed, metafy([]byte(cmd)))
+		case "fish":
+			cmd := strings.ReplaceAll(entry.cmd, "\\", "\\\\")
+			cmd = strings.ReplaceAll(cmd, "\n", "\\n")
+			fmt.Fprintf(bw, "- cmd: %s\n  when: %d\n", cmd, startTime(entry))
+		}
+	}
+	return bw.Flush()
+}
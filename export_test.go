@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"testing"
+	"time"
+)
+
+func TestMetafy(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"ls", "ls"},
+		// Cyrillic "у" is D1 83
+		{"у", "\xd1\x83\xa3"},
+		{"\x00", "\x83\x20"},
+		{"\xa2\xa3", "\x83\x82\xa3"},
+	}
+	for _, test := range tests {
+		got := metafy([]byte(test.in))
+		if string(got) != test.want {
+			t.Errorf("metafy(%q) = %q, want %q", test.in, got, test.want)
+		}
+		if back := unmetafy(got); string(back) != test.in {
+			t.Errorf("unmetafy(metafy(%q)) = %q", test.in, back)
+		}
+	}
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	commands := []string{
+		"ls -la",
+		"echo у тебя",
+		"echo тут ü 日本",
+		"for i in 1 2\ndo echo $i\ndone",
+		`printf 'a\nb' \\ c`,
+	}
+	var results list.List
+	for i, cmd := range commands {
+		entry := HistoryEntry{cmd: cmd, timestamp: time.Unix(int64(1700000000+10*i), 0), duration: i}
+		results.PushBack(&entry)
+	}
+
+	for _, format := range exportFormats {
+		var buf bytes.Buffer
+		if err := exportHistory(&buf, format, results); err != nil {
+			t.Fatalf("%s: %s", format, err.Error())
+		}
+		imported, err := parseHistory(&buf, format)
+		if err != nil {
+			t.Fatalf("%s: %s", format, err.Error())
+		}
+		if len(imported) != len(commands) {
+			t.Errorf("%s: imported %d commands, want %d: %+v", format, len(imported), len(commands), imported)
+			continue
+		}
+		for i, c := range imported {
+			if c.cmd != commands[i] {
+				t.Errorf("%s: command %d is %q, want %q", format, i, c.cmd, commands[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"container/list"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	fuzzyScoreMatch       = 16
+	fuzzyBonusBoundary    = 10
+	fuzzyBonusConsecutive = 8
+	fuzzyPenaltyGap       = 1
+	fuzzyMaxGapPenalty    = 12
+	fuzzyBonusFrequency   = 6
+)
+
+type fuzzyCandidate struct {
+	cmd       string
+	positions []int
+	score     int
+	count     int
+	entry     *HistoryEntry // most recent occurrence
+}
+
+func isWordBoundary(r rune) bool {
+	return unicode.IsSpace(r) || strings.ContainsRune("/-_.,=:;|&'\"()", r)
+}
+
+// fuzzyMatch reports whether all runes of pattern appear in cmd in the same
+// order, ignoring case. On success, the rune indices of the matched characters
+// are returned together with a score that rewards consecutive matches and
+// matches at the start of words. Among all possible alignments, the one with
+// the highest score is chosen.
+func fuzzyMatch(pattern []rune, cmd []rune) (score int, positions []int, ok bool) {
+	if len(pattern) == 0 {
+		return 0, nil, true
+	}
+
+	// occurrences[i] lists the indices in cmd where pattern[i] could be matched
+	occurrences := make([][]int, len(pattern))
+	for i, pr := range pattern {
+		pr = unicode.ToLower(pr)
+		for j, r := range cmd {
+			if unicode.ToLower(r) == pr {
+				occurrences[i] = append(occurrences[i], j)
+			}
+		}
+		if len(occurrences[i]) == 0 {
+			return 0, nil, false
+		}
+	}
+
+	// best[i][k] is the highest score of matching pattern[:i+1] with pattern[i]
+	// placed at occurrences[i][k], prev[i][k] the choice made for pattern[i-1]
+	best := make([][]int, len(pattern))
+	prev := make([][]int, len(pattern))
+	for i := range pattern {
+		best[i] = make([]int, len(occurrences[i]))
+		prev[i] = make([]int, len(occurrences[i]))
+		for k, pos := range occurrences[i] {
+			best[i][k] = math.MinInt32
+			prev[i][k] = -1
+			charScore := fuzzyScoreMatch
+			if pos == 0 || isWordBoundary(cmd[pos-1]) {
+				charScore += fuzzyBonusBoundary
+			}
+			if i == 0 {
+				best[i][k] = charScore
+				continue
+			}
+			for l, prevPos := range occurrences[i-1] {
+				if prevPos >= pos || best[i-1][l] == math.MinInt32 {
+					continue
+				}
+				candidate := best[i-1][l] + charScore
+				if gap := pos - prevPos - 1; gap == 0 {
+					candidate += fuzzyBonusConsecutive
+				} else {
+					penalty := gap * fuzzyPenaltyGap
+					if penalty > fuzzyMaxGapPenalty {
+						penalty = fuzzyMaxGapPenalty
+					}
+					candidate -= penalty
+				}
+				if candidate > best[i][k] {
+					best[i][k] = candidate
+					prev[i][k] = l
+				}
+			}
+		}
+	}
+
+	last := len(pattern) - 1
+	bestK := -1
+	for k, sc := range best[last] {
+		if sc != math.MinInt32 && (bestK < 0 || sc > best[last][bestK]) {
+			bestK = k
+		}
+	}
+	if bestK < 0 {
+		return 0, nil, false
+	}
+
+	score = best[last][bestK]
+	positions = make([]int, len(pattern))
+	for i, k := last, bestK; i >= 0; i-- {
+		positions[i] = occurrences[i][k]
+		k = prev[i][k]
+	}
+	return score, positions, true
+}
+
+func recencyBonus(lastUsed time.Time) int {
+	age := time.Since(lastUsed)
+	switch {
+	case age < time.Hour:
+		return 12
+	case age < 24*time.Hour:
+		return 8
+	case age < 7*24*time.Hour:
+		return 4
+	case age < 30*24*time.Hour:
+		return 2
+	}
+	return 0
+}
+
+// rankFuzzy matches pattern against the commands in results and returns the
+// distinct matching commands, best match first. Commands used often and
+// recently are ranked higher.
+func rankFuzzy(pattern string, results list.List) []fuzzyCandidate {
+	patternRunes := []rune(pattern)
+	byCmd := make(map[string]*fuzzyCandidate)
+	var candidates []*fuzzyCandidate
+
+	for e := results.Front(); e != nil; e = e.Next() {
+		entry, ok := e.Value.(*HistoryEntry)
+		if !ok {
+			log.Panic("Failed to retrieve entries")
+		}
+		if c, seen := byCmd[entry.cmd]; seen {
+			c.count++
+			if entry.timestamp.After(c.entry.timestamp) {
+				c.entry = entry
+			}
+			continue
+		}
+		score, positions, ok := fuzzyMatch(patternRunes, []rune(entry.cmd))
+		if !ok {
+			continue
+		}
+		c := &fuzzyCandidate{cmd: entry.cmd, positions: positions, score: score, count: 1, entry: entry}
+		byCmd[entry.cmd] = c
+		candidates = append(candidates, c)
+	}
+
+	ranked := make([]fuzzyCandidate, 0, len(candidates))
+	for _, c := range candidates {
+		c.score += int(fuzzyBonusFrequency*math.Log2(float64(c.count))) + recencyBonus(c.entry.timestamp)
+		ranked = append(ranked, *c)
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].score > ranked[j].score
+	})
+	return ranked
+}
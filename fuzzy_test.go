@@ -0,0 +1,88 @@
+package main
+
+import (
+	"container/list"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFuzzyMatch(t *testing.T) {
+	tests := []struct {
+		pattern   string
+		cmd       string
+		ok        bool
+		positions []int
+	}{
+		{"", "ls", true, nil},
+		{"gco", "git checkout", true, []int{0, 4, 9}},
+		{"GCO", "git checkout", true, []int{0, 4, 9}},
+		{"dcu", "docker compose up", true, []int{0, 7, 15}},
+		{"ls", "ls -la", true, []int{0, 1}},
+		{"zz", "ls -la", false, nil},
+		{"ba", "abc", false, nil},
+		{"ü", "echo über", true, []int{5}},
+	}
+	for _, test := range tests {
+		_, positions, ok := fuzzyMatch([]rune(test.pattern), []rune(test.cmd))
+		if ok != test.ok {
+			t.Errorf("fuzzyMatch(%q, %q): ok = %v, want %v", test.pattern, test.cmd, ok, test.ok)
+			continue
+		}
+		if ok && !reflect.DeepEqual(positions, test.positions) {
+			t.Errorf("fuzzyMatch(%q, %q): positions = %v, want %v", test.pattern, test.cmd, positions, test.positions)
+		}
+	}
+}
+
+func TestFuzzyMatchScore(t *testing.T) {
+	tests := []struct {
+		pattern string
+		better  string
+		worse   string
+	}{
+		// Consecutive characters
+		{"make", "make test", "mark knee"},
+		// Word boundaries
+		{"gp", "git push", "grep"},
+	}
+	for _, test := range tests {
+		better, _, ok1 := fuzzyMatch([]rune(test.pattern), []rune(test.better))
+		worse, _, ok2 := fuzzyMatch([]rune(test.pattern), []rune(test.worse))
+		if !ok1 || !ok2 {
+			t.Errorf("fuzzyMatch(%q): expected both %q and %q to match", test.pattern, test.better, test.worse)
+			continue
+		}
+		if better <= worse {
+			t.Errorf("fuzzyMatch(%q): %q scored %d, not more than %q with %d", test.pattern, test.better, better, test.worse, worse)
+		}
+	}
+}
+
+func TestRankFuzzy(t *testing.T) {
+	now := time.Now()
+	var results list.List
+	for _, entry := range []HistoryEntry{
+		{cmd: "git push", timestamp: now.Add(-90 * 24 * time.Hour)},
+		{cmd: "grep pattern", timestamp: now.Add(-90 * 24 * time.Hour)},
+		{cmd: "gpg --list-keys", timestamp: now.Add(-90 * 24 * time.Hour)},
+		{cmd: "git push", timestamp: now.Add(-time.Minute)},
+		{cmd: "ls", timestamp: now},
+	} {
+		entry := entry
+		results.PushBack(&entry)
+	}
+
+	ranked := rankFuzzy("gp", results)
+	var cmds []string
+	for _, c := range ranked {
+		cmds = append(cmds, c.cmd)
+	}
+	want := []string{"git push", "gpg --list-keys", "grep pattern"}
+	if !reflect.DeepEqual(cmds, want) {
+		t.Fatalf("rankFuzzy = %v, want %v", cmds, want)
+	}
+	if ranked[0].count != 2 || !ranked[0].entry.timestamp.Equal(now.Add(-time.Minute)) {
+		t.Errorf("rankFuzzy: 'git push' has count %d and time %v, want 2 and the most recent run", ranked[0].count, ranked[0].entry.timestamp)
+	}
+}
@@ -1,25 +1,37 @@
 package main
 
 import (
+	"container/list"
 	"database/sql"
 	"hs9001/liner"
 	"io"
 	"log"
 	"path/filepath"
+	"regexp"
 	"strings"
+	"time"
+	"unicode/utf8"
 )
 
 type history struct {
 	conn *sql.DB
+	// daemon is used instead of conn if the daemon is running
+	daemon *daemonClient
+	// details of the most recent occurrence of each line returned by the
+	// last search, see GetHistoryItem
+	details map[string]*HistoryEntry
 }
 
-func createSearchOpts(query string, mode int) searchopts {
+// ctrlrCandidates is the number of commands the ranked search modes choose
+// the best matches from.
+const ctrlrCandidates = 5000
+
+func createSearchOpts(mode int) searchopts {
 	opts := searchopts{}
 	o := "DESC"
 	opts.order = &o
-	lim := 100
+	lim := config.ctrlrLimit
 	opts.limit = &lim
-	opts.command = &query
 
 	switch mode {
 	case liner.ModeGlobal:
@@ -36,10 +48,50 @@ func createSearchOpts(query string, mode int) searchopts {
 	return opts
 }
 
+// remember stores the details of entry, unless a more recent occurrence of the
+// same command has already been seen.
+func (h *history) remember(entry *HistoryEntry) {
+	if previous, ok := h.details[entry.cmd]; !ok || entry.timestamp.After(previous.timestamp) {
+		h.details[entry.cmd] = entry
+	}
+}
+
+// search queries the daemon or, if it is not running (anymore), the database.
+func (h *history) search(opts searchopts) list.List {
+	if h.daemon != nil {
+		results, err := h.daemon.search(opts)
+		if err == nil {
+			return results
+		}
+		h.daemon = nil
+	}
+	if h.conn == nil {
+		h.conn = openDatabase(databaseLocation())
+		if err := loadEncryption(h.conn); err != nil {
+			log.Panic(err)
+		}
+	}
+	return search(h.conn, opts)
+}
+
+func (h *history) GetHistoryItem(line string) (item liner.HistoryItem, ok bool) {
+	entry, ok := h.details[line]
+	if !ok {
+		return item, false
+	}
+	return liner.HistoryItem{
+		Line:    entry.cmd,
+		Workdir: entry.cwd,
+		Time:    entry.timestamp,
+		Retval:  entry.retval,
+	}, true
+}
+
 func (h *history) GetHistoryByPrefix(prefix string, mode int) (ph []string) {
 	cmdquery := prefix + "%"
-	opts := createSearchOpts(cmdquery, mode)
-	results := search(h.conn, opts)
+	opts := createSearchOpts(mode)
+	opts.command = &cmdquery
+	results := h.search(opts)
 	for e := results.Back(); e != nil; e = e.Prev() {
 		entry, ok := e.Value.(*HistoryEntry)
 		if !ok {
@@ -51,21 +103,104 @@ func (h *history) GetHistoryByPrefix(prefix string, mode int) (ph []string) {
 }
 
 func (h *history) GetHistoryByPattern(pattern string, mode int) (ph []string, pos []int) {
-	cmdquery := "%" + pattern + "%"
-	opts := createSearchOpts(cmdquery, mode)
+	opts := createSearchOpts(mode)
+	opts.setSubstring(pattern)
 
-	results := search(h.conn, opts)
+	h.details = make(map[string]*HistoryEntry)
+	if config.ctrlrRanking == "frecency" {
+		workdir, err := filepath.Abs(".")
+		if err != nil {
+			panic(err)
+		}
+		lim := ctrlrCandidates
+		opts.limit = &lim
+		ranked := rankFrecency(h.search(opts), newFrecencyDirs(workdir), time.Now())
+		if len(ranked) > config.ctrlrLimit {
+			ranked = ranked[:config.ctrlrLimit]
+		}
+		for i := len(ranked) - 1; i >= 0; i-- {
+			h.remember(ranked[i].entry)
+			ph = append(ph, ranked[i].cmd)
+			pos = append(pos, strings.Index(strings.ToLower(ranked[i].cmd), strings.ToLower(pattern)))
+		}
+		return
+	}
+
+	results := h.search(opts)
 	for e := results.Back(); e != nil; e = e.Prev() {
 		entry, ok := e.Value.(*HistoryEntry)
 		if !ok {
 			log.Panic("Failed to retrieve entries")
 		}
+		h.remember(entry)
 		ph = append(ph, entry.cmd)
 		pos = append(pos, strings.Index(strings.ToLower(entry.cmd), strings.ToLower(pattern)))
 	}
 	return
 }
 
+func (h *history) GetHistoryByFuzzyPattern(pattern string, mode int) (ph []string, matches [][]int) {
+	// Narrow down the candidates in the database first, '%a%b%c%' only
+	// matches commands containing the pattern as a subsequence
+	var sb strings.Builder
+	sb.WriteRune('%')
+	for _, r := range pattern {
+		sb.WriteRune(r)
+		sb.WriteRune('%')
+	}
+	cmdquery := sb.String()
+	opts := createSearchOpts(mode)
+	opts.command = &cmdquery
+	lim := ctrlrCandidates
+	opts.limit = &lim
+
+	ranked := rankFuzzy(pattern, h.search(opts))
+	if len(ranked) > config.ctrlrLimit {
+		ranked = ranked[:config.ctrlrLimit]
+	}
+	h.details = make(map[string]*HistoryEntry)
+	for i := len(ranked) - 1; i >= 0; i-- {
+		h.remember(ranked[i].entry)
+		ph = append(ph, ranked[i].cmd)
+		matches = append(matches, ranked[i].positions)
+	}
+	return
+}
+
+func (h *history) GetHistoryByRegex(pattern string, mode int) (ph []string, matches [][]int) {
+	rgx, err := regexp.Compile(pattern)
+	if err != nil {
+		// Most likely the expression is still being typed
+		return
+	}
+	opts := createSearchOpts(mode)
+	opts.regex = rgx
+
+	h.details = make(map[string]*HistoryEntry)
+	results := h.search(opts)
+	for e := results.Back(); e != nil; e = e.Prev() {
+		entry, ok := e.Value.(*HistoryEntry)
+		if !ok {
+			log.Panic("Failed to retrieve entries")
+		}
+		h.remember(entry)
+		ph = append(ph, entry.cmd)
+
+		var positions []int
+		loc := rgx.FindStringIndex(entry.cmd)
+		for i := range entry.cmd {
+			if i >= loc[1] {
+				break
+			}
+			if i >= loc[0] {
+				positions = append(positions, utf8.RuneCountInString(entry.cmd[:i]))
+			}
+		}
+		matches = append(matches, positions)
+	}
+	return
+}
+
 func (h *history) ReadHistory(r io.Reader) (num int, err error) {
 	panic("not implemented")
 }
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// ignoreRules decide which commands are not recorded at all. They are
+// configured in the [ignore] section of the config file, each rule being
+// given as "<kind> <argument>" to parseIgnoreRules:
+//
+//	ignorespace        commands starting with a space
+//	glob <pattern>     commands matching the glob pattern, e.g. "ls*"
+//	regex <regexp>     commands matching the regular expression
+//	dir <pattern>      commands run in a directory matching the glob pattern
+type ignoreRules struct {
+	ignoreSpace bool
+	commands    []*regexp.Regexp
+	workdirs    []*regexp.Regexp
+}
+
+// globToRegexp converts a glob pattern into an anchored regular expression.
+// Unlike path.Match, '*' also matches '/' so "/tmp/*" covers all
+// subdirectories as well.
+func globToRegexp(glob string) *regexp.Regexp {
+	var sb strings.Builder
+	sb.WriteString("^")
+	for _, r := range glob {
+		switch r {
+		case '*':
+			sb.WriteString(".*")
+		case '?':
+			sb.WriteString(".")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	sb.WriteString("$")
+	return regexp.MustCompile(sb.String())
+}
+
+func parseIgnoreRules(lines []string) ignoreRules {
+	var rules ignoreRules
+	for _, line := range lines {
+		kind, arg := line, ""
+		if i := strings.IndexAny(line, " \t"); i >= 0 {
+			kind, arg = line[:i], strings.TrimSpace(line[i+1:])
+		}
+		switch kind {
+		case "ignorespace":
+			rules.ignoreSpace = true
+		case "glob":
+			rules.commands = append(rules.commands, globToRegexp(arg))
+		case "regex":
+			rgx, err := regexp.Compile(arg)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Ignoring invalid ignore rule '%s': %s\n", line, err.Error())
+				continue
+			}
+			rules.commands = append(rules.commands, rgx)
+		case "dir":
+			rules.workdirs = append(rules.workdirs, globToRegexp(arg))
+		default:
+			fmt.Fprintf(os.Stderr, "Ignoring unknown ignore rule '%s'\n", line)
+		}
+	}
+	return rules
+}
+
+func (rules *ignoreRules) ignored(cmd string, workdir string) bool {
+	if rules.ignoreSpace && strings.HasPrefix(cmd, " ") {
+		return true
+	}
+	for _, rgx := range rules.commands {
+		if rgx.MatchString(cmd) {
+			return true
+		}
+	}
+	for _, rgx := range rules.workdirs {
+		if rgx.MatchString(workdir) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,62 @@
+package main
+
+import "testing"
+
+func TestGlobToRegexp(t *testing.T) {
+	tests := []struct {
+		glob  string
+		input string
+		match bool
+	}{
+		{"ls*", "ls", true},
+		{"ls*", "ls -la", true},
+		{"ls*", "cd; ls", false},
+		{"ls?", "lsd", true},
+		{"ls?", "ls", false},
+		{"/tmp/*", "/tmp/a/b", true},
+		{"/tmp/*", "/tmpfoo", false},
+		// Regular expression syntax is taken literally
+		{"rm -rf .", "rm -rf .", true},
+		{"rm -rf .", "rm -rf x", false},
+		{"echo (a|b)+", "echo (a|b)+", true},
+		{"echo (a|b)+", "echo a", false},
+		{"[abc]", "a", false},
+		{"[abc]", "[abc]", true},
+		{`C:\*`, `C:\Users`, true},
+	}
+	for _, test := range tests {
+		if got := globToRegexp(test.glob).MatchString(test.input); got != test.match {
+			t.Errorf("glob %q matching %q = %v, want %v", test.glob, test.input, got, test.match)
+		}
+	}
+}
+
+func TestIgnoreRules(t *testing.T) {
+	rules := parseIgnoreRules([]string{
+		"ignorespace",
+		"glob ls*",
+		"regex ^(exit|clear)$",
+		"dir /home/me/secret*",
+		"regex (",
+		"unknown rule",
+	})
+	tests := []struct {
+		cmd     string
+		workdir string
+		ignored bool
+	}{
+		{"make", "/home/me", false},
+		{" make", "/home/me", true},
+		{"ls -la", "/home/me", true},
+		{"exit", "/home/me", true},
+		{"exit 1", "/home/me", false},
+		{"make", "/home/me/secret", true},
+		{"make", "/home/me/secret/sub", true},
+		{"make", "/home/me/public", false},
+	}
+	for _, test := range tests {
+		if got := rules.ignored(test.cmd, test.workdir); got != test.ignored {
+			t.Errorf("ignored(%q, %q) = %v, want %v", test.cmd, test.workdir, got, test.ignored)
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var importFormats = []string{"auto", "bash", "zsh", "fish"}
+
+var bashTimestampRegex = regexp.MustCompile(`^#(\d+)$`)
+
+var zshExtendedRegex = regexp.MustCompile(`(?s)^: *(\d+):(\d+);(.*)$`)
+
+// importedCommand is a command read from a shell's history file. Unknown
+// timestamps and durations are 0 and -1 respectively.
+type importedCommand struct {
+	cmd      string
+	started  int64
+	duration int
+	source   string
+}
+
+// detectFormat guesses the format of a history file from its first line.
+func detectFormat(firstLine string) string {
+	if strings.HasPrefix(firstLine, "- cmd: ") {
+		return "fish"
+	}
+	if zshExtendedRegex.MatchString(firstLine) {
+		return "zsh"
+	}
+	return "bash"
+}
+
+// unmetafy reverts zsh's encoding of bytes >= 0x83 in its history file, which
+// are written as 0x83 followed by the byte XOR 32.
+func unmetafy(line []byte) []byte {
+	if bytes.IndexByte(line, 0x83) < 0 {
+		return line
+	}
+	result := make([]byte, 0, len(line))
+	for i := 0; i < len(line); i++ {
+		if line[i] == 0x83 && i+1 < len(line) {
+			i++
+			result = append(result, line[i]^32)
+		} else {
+			result = append(result, line[i])
+		}
+	}
+	return result
+}
+
+// parseBashHistory reads a bash history file. With HISTTIMEFORMAT set, bash
+// precedes every command with a "#<unix time>" line; all lines up to the next
+// timestamp then belong to the same (multi-line) command.
+func parseBashHistory(lines []string) []importedCommand {
+	var commands []importedCommand
+	var started int64
+	awaitingCommand := false
+	for _, line := range lines {
+		if m := bashTimestampRegex.FindStringSubmatch(line); m != nil {
+			started, _ = strconv.ParseInt(m[1], 10, 64)
+			awaitingCommand = true
+			continue
+		}
+		if started > 0 && !awaitingCommand && len(commands) > 0 {
+			commands[len(commands)-1].cmd += "\n" + line
+			continue
+		}
+		commands = append(commands, importedCommand{cmd: line, started: started, duration: -1})
+		awaitingCommand = false
+	}
+	return commands
+}
+
+func parseZshHistory(lines []string) []importedCommand {
+	var commands []importedCommand
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		// Multi-line commands are continued with a trailing backslash
+		for strings.HasSuffix(line, "\\") && i+1 < len(lines) {
+			i++
+			line = line[:len(line)-1] + "\n" + lines[i]
+		}
+
+		m := zshExtendedRegex.FindStringSubmatch(line)
+		if m == nil {
+			// History written without EXTENDED_HISTORY
+			commands = append(commands, importedCommand{cmd: line, duration: -1})
+			continue
+		}
+		started, _ := strconv.ParseInt(m[1], 10, 64)
+		duration, _ := strconv.Atoi(m[2])
+		commands = append(commands, importedCommand{cmd: m[3], started: started, duration: duration})
+	}
+	return commands
+}
+
+func unescapeFish(cmd string) string {
+	var sb strings.Builder
+	for i := 0; i < len(cmd); i++ {
+		if cmd[i] == '\\' && i+1 < len(cmd) {
+			switch cmd[i+1] {
+			case 'n':
+				sb.WriteByte('\n')
+				i++
+				continue
+			case '\\':
+				sb.WriteByte('\\')
+				i++
+				continue
+			}
+		}
+		sb.WriteByte(cmd[i])
+	}
+	return sb.String()
+}
+
+func parseFishHistory(lines []string) []importedCommand {
+	var commands []importedCommand
+	for _, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "- cmd: "):
+			cmd := unescapeFish(strings.TrimPrefix(line, "- cmd: "))
+			commands = append(commands, importedCommand{cmd: cmd, duration: -1})
+		case strings.HasPrefix(line, "  when: ") && len(commands) > 0:
+			started, err := strconv.ParseInt(strings.TrimPrefix(line, "  when: "), 10, 64)
+			if err == nil {
+				commands[len(commands)-1].started = started
+			}
+		}
+		// The paths a command referred to are of no use to us
+	}
+	return commands
+}
+
+// parseHistory reads a history file in the given format, "auto" detects
+// the format.
+func parseHistory(r io.Reader, format string) ([]importedCommand, error) {
+	known := false
+	for _, f := range importFormats {
+		if f == format {
+			known = true
+			break
+		}
+	}
+	if !known {
+		return nil, fmt.Errorf("unknown format '%s'", format)
+	}
+
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if format == "auto" {
+		format = "bash"
+		for _, line := range lines {
+			if strings.TrimSpace(line) != "" {
+				format = detectFormat(line)
+				break
+			}
+		}
+	}
+	if format == "zsh" {
+		// Only zsh metafies, in other files 0x83 is part of a UTF-8 sequence
+		for i, line := range lines {
+			lines[i] = string(unmetafy([]byte(line)))
+		}
+	}
+
+	var commands []importedCommand
+	switch format {
+	case "zsh":
+		commands = parseZshHistory(lines)
+	case "fish":
+		commands = parseFishHistory(lines)
+	default:
+		commands = parseBashHistory(lines)
+	}
+	for i := range commands {
+		commands[i].source = format + "_history"
+	}
+	return commands, nil
+}
+
+// importKey identifies an imported command. Commands without a timestamp
+// share the key of every other run of the same command.
+type importKey struct {
+	cmd       string // sealed
+	timestamp int64
+}
+
+// existingCommands counts the entries of hostname in the history by key.
+func existingCommands(conn *sql.DB, hostname string) map[importKey]int {
+	rows, err := conn.Query("SELECT command, timestamp, COUNT(id) FROM history WHERE hostname = ? GROUP BY command, timestamp", hostname)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer rows.Close()
+
+	counts := make(map[importKey]int)
+	for rows.Next() {
+		var key importKey
+		var count int
+		err = rows.Scan(&key.cmd, &key.timestamp, &count)
+		if err != nil {
+			log.Panic(err)
+		}
+		counts[key] = count
+	}
+	return counts
+}
+
+// importHistory adds the commands to the history, skipping those which have
+// been imported before. It returns the number of commands added.
+//
+// A command is skipped as long as the file contained no more runs of it at
+// that time than the history already has, so importing a file again adds
+// nothing while repeated commands without a timestamp are all kept.
+func importHistory(conn *sql.DB, commands []importedCommand) int {
+	imported := 0
+	redactor := newRedactor(config.redact)
+	var existing map[importKey]int
+	seen := make(map[importKey]int)
+
+	_, err := conn.Exec("BEGIN;")
+	if err != nil {
+		log.Panic(err)
+	}
+	stmt := prepareAdd(conn)
+	defer stmt.Close()
+
+	for _, c := range commands {
+		entry := NewHistoryEntry(redactor.redact(c.cmd), -9001)
+		entry.cwd = ""
+		entry.session = ""
+		entry.duration = c.duration
+		entry.source = c.source
+		entry.timestamp = time.Unix(0, 0)
+		if c.started > 0 {
+			// We record the time a command has finished
+			finished := c.started
+			if c.duration > 0 {
+				finished += int64(c.duration)
+			}
+			entry.timestamp = time.Unix(finished, 0)
+		}
+		if existing == nil {
+			existing = existingCommands(conn, entry.hostname)
+		}
+		key := importKey{sealColumn(entry.cmd), entry.timestamp.Unix()}
+		seen[key]++
+		if seen[key] <= existing[key] {
+			continue
+		}
+		addPrepared(stmt, entry)
+		imported++
+	}
+
+	_, err = conn.Exec("END;")
+	if err != nil {
+		log.Panic(err)
+	}
+	return imported
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseHistory(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		input  string
+		want   []importedCommand
+	}{
+		{
+			name:   "zsh extended",
+			format: "zsh",
+			input:  ": 1700000000:5;make test\n: 1700000010:0;ls\n",
+			want: []importedCommand{
+				{cmd: "make test", started: 1700000000, duration: 5, source: "zsh_history"},
+				{cmd: "ls", started: 1700000010, duration: 0, source: "zsh_history"},
+			},
+		},
+		{
+			name:   "zsh multi-line",
+			format: "zsh",
+			input:  ": 1700000000:0;for i in 1 2\\\ndo echo $i\\\ndone\n",
+			want: []importedCommand{
+				{cmd: "for i in 1 2\ndo echo $i\ndone", started: 1700000000, duration: 0, source: "zsh_history"},
+			},
+		},
+		{
+			name:   "zsh without extended history",
+			format: "zsh",
+			input:  "ls\ncd /tmp\n",
+			want: []importedCommand{
+				{cmd: "ls", duration: -1, source: "zsh_history"},
+				{cmd: "cd /tmp", duration: -1, source: "zsh_history"},
+			},
+		},
+		{
+			name:   "zsh metafied",
+			format: "auto",
+			input:  string(metafy([]byte(": 1700000000:0;echo тут ü\n"))),
+			want: []importedCommand{
+				{cmd: "echo тут ü", started: 1700000000, duration: 0, source: "zsh_history"},
+			},
+		},
+		{
+			name:   "fish",
+			format: "auto",
+			input:  "- cmd: echo тут\n  when: 1700000000\n- cmd: cat a\\nb \\\\n\n  when: 1700000005\n  paths:\n    - a\n",
+			want: []importedCommand{
+				{cmd: "echo тут", started: 1700000000, duration: -1, source: "fish_history"},
+				{cmd: "cat a\nb \\n", started: 1700000005, duration: -1, source: "fish_history"},
+			},
+		},
+		{
+			name:   "fish without timestamp",
+			format: "fish",
+			input:  "- cmd: ls\n",
+			want: []importedCommand{
+				{cmd: "ls", duration: -1, source: "fish_history"},
+			},
+		},
+		{
+			name:   "bash UTF-8",
+			format: "auto",
+			input:  "echo тут\n",
+			want: []importedCommand{
+				{cmd: "echo тут", duration: -1, source: "bash_history"},
+			},
+		},
+	}
+	for _, test := range tests {
+		got, err := parseHistory(strings.NewReader(test.input), test.format)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err.Error())
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestParseHistoryUnknownFormat(t *testing.T) {
+	if _, err := parseHistory(strings.NewReader("ls\n"), "csh"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+func TestDetectFormat(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+	}{
+		{"- cmd: ls", "fish"},
+		{": 1700000000:0;ls", "zsh"},
+		{"#1700000000", "bash"},
+		{"ls -la", "bash"},
+	}
+	for _, test := range tests {
+		if got := detectFormat(test.line); got != test.want {
+			t.Errorf("detectFormat(%q) = %s, want %s", test.line, got, test.want)
+		}
+	}
+}
+
+func TestParseBashHistory(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string
+		want  []importedCommand
+	}{
+		{
+			name:  "plain",
+			lines: []string{"ls", "ls", "cd /tmp"},
+			want: []importedCommand{
+				{cmd: "ls", duration: -1},
+				{cmd: "ls", duration: -1},
+				{cmd: "cd /tmp", duration: -1},
+			},
+		},
+		{
+			name:  "HISTTIMEFORMAT",
+			lines: []string{"#1700000000", "ls", "#1700000010", "make"},
+			want: []importedCommand{
+				{cmd: "ls", started: 1700000000, duration: -1},
+				{cmd: "make", started: 1700000010, duration: -1},
+			},
+		},
+		{
+			name:  "multi-line command",
+			lines: []string{"#1700000000", "for i in 1 2", "do echo $i", "done", "#1700000010", "ls"},
+			want: []importedCommand{
+				{cmd: "for i in 1 2\ndo echo $i\ndone", started: 1700000000, duration: -1},
+				{cmd: "ls", started: 1700000010, duration: -1},
+			},
+		},
+		{
+			name:  "commands before the first timestamp",
+			lines: []string{"ls", "pwd", "#1700000000", "make"},
+			want: []importedCommand{
+				{cmd: "ls", duration: -1},
+				{cmd: "pwd", duration: -1},
+				{cmd: "make", started: 1700000000, duration: -1},
+			},
+		},
+		{
+			name:  "comment which is not a timestamp",
+			lines: []string{"#1700000000", "ls", "#notatime"},
+			want: []importedCommand{
+				{cmd: "ls\n#notatime", started: 1700000000, duration: -1},
+			},
+		},
+	}
+	for _, test := range tests {
+		if got := parseBashHistory(test.lines); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestImportHistory(t *testing.T) {
+	conn := openDatabase(filepath.Join(t.TempDir(), "db.sqlite"))
+	defer conn.Close()
+
+	parse := func(input string) []importedCommand {
+		commands, err := parseHistory(strings.NewReader(input), "bash")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return commands
+	}
+	count := func() int {
+		results := search(conn, searchopts{})
+		return results.Len()
+	}
+
+	file := "ls\nls\ncd /tmp\nls\n"
+	if n := importHistory(conn, parse(file)); n != 4 {
+		t.Errorf("first import added %d commands, want 4", n)
+	}
+	if n := importHistory(conn, parse(file)); n != 0 || count() != 4 {
+		t.Errorf("second import added %d commands, %d in total, want 0 and 4", n, count())
+	}
+	if n := importHistory(conn, parse(file+"ls\n")); n != 1 || count() != 5 {
+		t.Errorf("import of the extended file added %d commands, %d in total, want 1 and 5", n, count())
+	}
+
+	timestamped := "#1700000000\nmake\n#1700000000\nmake\n#1700000100\nmake\n"
+	if n := importHistory(conn, parse(timestamped)); n != 3 {
+		t.Errorf("import with timestamps added %d commands, want 3", n)
+	}
+	if n := importHistory(conn, parse(timestamped)); n != 0 {
+		t.Errorf("second import with timestamps added %d commands, want 0", n)
+	}
+}
@@ -30,6 +30,9 @@ type commonState struct {
 	shouldRestart     ShouldRestart
 	noBeep            bool
 	needRefresh       bool
+	highlight         []int
+	searchListRows    int
+	searchMode        int
 }
 
 type HistoryProvider interface {
@@ -39,6 +42,9 @@ type HistoryProvider interface {
 	ClearHistory()
 	GetHistoryByPrefix(prefix string, mode int) (ph []string)
 	GetHistoryByPattern(pattern string, mode int) (ph []string, pos []int)
+	GetHistoryByFuzzyPattern(pattern string, mode int) (ph []string, matches [][]int)
+	GetHistoryByRegex(pattern string, mode int) (ph []string, matches [][]int)
+	GetHistoryItem(line string) (item HistoryItem, ok bool)
 	RLock()
 	RUnlock()
 }
@@ -101,6 +107,12 @@ func (s *State) getHistoryByPrefix(prefix string, mode int) (ph []string) {
 func (s *State) getHistoryByPattern(pattern string, mode int) (ph []string, pos []int) {
 	return s.historyProvider.GetHistoryByPattern(pattern, mode)
 }
+func (s *State) getHistoryByFuzzyPattern(pattern string, mode int) (ph []string, matches [][]int) {
+	return s.historyProvider.GetHistoryByFuzzyPattern(pattern, mode)
+}
+func (s *State) getHistoryByRegex(pattern string, mode int) (ph []string, matches [][]int) {
+	return s.historyProvider.GetHistoryByRegex(pattern, mode)
+}
 
 // SetHistoryProvider allows you to set a custom provider
 // for reading, writing and searching history.
@@ -95,6 +95,14 @@ const (
 	ModeWorkdir
 )
 
+type matchStyle int
+
+const (
+	matchSubstring matchStyle = iota
+	matchFuzzy
+	matchRegex
+)
+
 func (s *State) refresh(prompt []rune, buf []rune, pos int) error {
 	if s.columns == 0 {
 		return ErrInternal
@@ -122,7 +130,7 @@ func (s *State) refreshSingleLine(prompt []rune, buf []rune, pos int) error {
 	}
 	pos = countGlyphs(buf[:pos])
 	if pLen+bLen < s.columns {
-		_, err = fmt.Print(string(buf))
+		_, err = s.printBuf(buf, 0)
 		s.eraseLine()
 		s.cursorPos(pLen + pos)
 	} else {
@@ -155,7 +163,7 @@ func (s *State) refreshSingleLine(prompt []rune, buf []rune, pos int) error {
 		if start > 0 {
 			fmt.Print("{")
 		}
-		fmt.Print(string(line))
+		s.printBuf(line, startRune)
 		if end < bLen {
 			fmt.Print("}")
 		}
@@ -209,7 +217,7 @@ func (s *State) refreshMultiLine(prompt []rune, buf []rune, pos int) error {
 	if _, err := fmt.Print(string(prompt)); err != nil {
 		return err
 	}
-	if _, err := fmt.Print(string(buf)); err != nil {
+	if _, err := s.printBuf(buf, 0); err != nil {
 		return err
 	}
 
@@ -237,6 +245,47 @@ func (s *State) refreshMultiLine(prompt []rune, buf []rune, pos int) error {
 	return nil
 }
 
+// printBuf prints buf, emphasizing the runes listed in s.highlight. offset
+// is the index of buf[0] in the complete line the highlight refers to.
+func (s *State) printBuf(buf []rune, offset int) (int, error) {
+	if len(s.highlight) == 0 {
+		return fmt.Print(string(buf))
+	}
+	highlighted := make(map[int]bool, len(s.highlight))
+	for _, i := range s.highlight {
+		highlighted[i-offset] = true
+	}
+	var sb strings.Builder
+	for i, r := range buf {
+		if highlighted[i] {
+			sb.WriteString("\x1b[1;4m")
+			sb.WriteRune(r)
+			sb.WriteString("\x1b[0m")
+		} else {
+			sb.WriteRune(r)
+		}
+	}
+	return fmt.Print(sb.String())
+}
+
+// eraseMultiLine erases all rows used by the prompt in multi line mode, for
+// example after the window has been resized.
+func (s *State) eraseMultiLine() {
+	if !s.multiLineMode {
+		return
+	}
+	if s.maxRows-s.cursorRows > 0 {
+		s.moveDown(s.maxRows - s.cursorRows)
+	}
+	for i := 0; i < s.maxRows-1; i++ {
+		s.cursorPos(0)
+		s.eraseLine()
+		s.moveUp(1)
+	}
+	s.maxRows = 1
+	s.cursorRows = 1
+}
+
 func (s *State) resetMultiLine(prompt []rune, buf []rune, pos int) {
 	columns := countMultiLineGlyphs(prompt, s.columns, 0)
 	columns = countMultiLineGlyphs(buf[:pos], s.columns, columns)
@@ -417,22 +466,35 @@ func (s *State) tabComplete(p []rune, line []rune, pos int) ([]rune, int, interf
 // reverse intelligent search, implements a bash-like history search.
 func (s *State) reverseISearch(origLine []rune, origPos int) ([]rune, int, interface{}, error) {
 	modeSelect := false
-	currentMode := ModeGlobal
+	currentMode := s.searchMode
+	style := matchSubstring
+	defer func() {
+		s.highlight = nil
+		if s.searchListRows > 0 {
+			s.eraseSearchList()
+		}
+	}()
 
 	getPrompt := func(arg string) string {
 		prompt := ""
 		switch currentMode {
 		case ModeWorkdir:
-			prompt = "(reverse:cwd)`%s': "
+			prompt = "(%s:cwd)`%s': "
 		case ModeGlobal:
-			prompt = "(reverse:global)`%s': "
+			prompt = "(%s:global)`%s': "
 		default:
 			panic("Invalid mode")
 		}
 		if modeSelect {
-			prompt = "(select mode)`%s': "
+			return fmt.Sprintf("(select mode)`%s': ", arg)
+		}
+		switch style {
+		case matchFuzzy:
+			return fmt.Sprintf(prompt, "fuzzy", arg)
+		case matchRegex:
+			return fmt.Sprintf(prompt, "regex", arg)
 		}
-		return fmt.Sprintf(prompt, arg)
+		return fmt.Sprintf(prompt, "reverse", arg)
 	}
 
 	err := s.refresh([]rune(getPrompt("")), origLine, origPos)
@@ -452,8 +514,73 @@ func (s *State) reverseISearch(origLine []rune, origPos int) ([]rune, int, inter
 		return []rune(getPrompt(search)), []rune(foundLine), foundPos
 	}
 
-	history, positions := s.getHistoryByPattern(string(line), currentMode)
-	historyPos := len(history) - 1
+	var history []string
+	var positions []int
+	var matches [][]int
+	historyPos := 0
+	listTop := 0 // index of the first entry in the candidate list
+
+	show := func() {
+		if historyPos > listTop {
+			listTop = historyPos
+		} else if historyPos <= listTop-s.searchListRows {
+			listTop = historyPos + s.searchListRows - 1
+		}
+		foundLine = history[historyPos]
+		foundPos = positions[historyPos]
+		if style != matchSubstring {
+			s.highlight = matches[historyPos]
+		}
+	}
+
+	// For each change of the search, display the best matching line of history
+	lookup := func() {
+		if style != matchSubstring {
+			if style == matchFuzzy {
+				history, matches = s.getHistoryByFuzzyPattern(string(line), currentMode)
+			} else {
+				history, matches = s.getHistoryByRegex(string(line), currentMode)
+			}
+			positions = make([]int, len(matches))
+			for i, m := range matches {
+				if len(m) > 0 {
+					positions[i] = m[0]
+				}
+			}
+		} else {
+			history, positions = s.getHistoryByPattern(string(line), currentMode)
+		}
+		s.highlight = nil
+		historyPos = len(history) - 1
+		listTop = historyPos
+		if len(history) > 0 {
+			show()
+		} else {
+			foundLine = ""
+			foundPos = 0
+		}
+	}
+	refresh := func() error {
+		err := s.refresh(getLine())
+		if err != nil || s.searchListRows == 0 {
+			return err
+		}
+		s.drawSearchList(history, listTop, historyPos)
+		return s.refresh(getLine())
+	}
+
+	lookup()
+	if s.searchListRows > 0 {
+		// Preselect the most recent match so it corresponds to the list
+		err = refresh()
+		if err != nil {
+			return origLine, origPos, rune(esc), err
+		}
+	} else {
+		foundLine = string(origLine)
+		foundPos = origPos
+		s.highlight = nil
+	}
 
 	for {
 		next, err := s.readNext()
@@ -467,8 +594,7 @@ func (s *State) reverseISearch(origLine []rune, origPos int) ([]rune, int, inter
 			case ctrlR: // Search backwards
 				if historyPos > 0 && historyPos < len(history) {
 					historyPos--
-					foundLine = history[historyPos]
-					foundPos = positions[historyPos]
+					show()
 				} else {
 					s.doBeep()
 				}
@@ -477,8 +603,7 @@ func (s *State) reverseISearch(origLine []rune, origPos int) ([]rune, int, inter
 			case ctrlS: // Search forward
 				if historyPos < len(history)-1 && historyPos >= 0 {
 					historyPos++
-					foundLine = history[historyPos]
-					foundPos = positions[historyPos]
+					show()
 				} else {
 					s.doBeep()
 				}
@@ -489,17 +614,7 @@ func (s *State) reverseISearch(origLine []rune, origPos int) ([]rune, int, inter
 					n := len(getSuffixGlyphs(line[:pos], 1))
 					line = append(line[:pos-n], line[pos:]...)
 					pos -= n
-
-					// For each char deleted, display the last matching line of history
-					history, positions := s.getHistoryByPattern(string(line), currentMode)
-					historyPos = len(history) - 1
-					if len(history) > 0 {
-						foundLine = history[historyPos]
-						foundPos = positions[historyPos]
-					} else {
-						foundLine = ""
-						foundPos = 0
-					}
+					lookup()
 				}
 			case ctrlG: // Cancel
 				return origLine, origPos, rune(esc), err
@@ -516,29 +631,53 @@ func (s *State) reverseISearch(origLine []rune, origPos int) ([]rune, int, inter
 						currentMode = ModeGlobal
 					case 'w':
 						currentMode = ModeWorkdir
+					case 'f':
+						if style == matchFuzzy {
+							style = matchSubstring
+						} else {
+							style = matchFuzzy
+						}
+					case 'r':
+						if style == matchRegex {
+							style = matchSubstring
+						} else {
+							style = matchRegex
+						}
 					}
 					modeSelect = false
+					lookup()
 					break
 				}
 
 				line = append(line[:pos], append([]rune{v}, line[pos:]...)...)
 				pos++
-
-				// For each keystroke typed, display the last matching line of history
-				history, positions = s.getHistoryByPattern(string(line), currentMode)
-				historyPos = len(history) - 1
-				if len(history) > 0 {
-					foundLine = history[historyPos]
-					foundPos = positions[historyPos]
+				lookup()
+			}
+		case action:
+			switch {
+			case v == winch:
+				s.eraseMultiLine()
+				s.cursorPos(0)
+				s.eraseRemainingScreen()
+			case v == up && s.searchListRows > 0:
+				if historyPos < len(history)-1 && historyPos >= 0 {
+					historyPos++
+					show()
 				} else {
-					foundLine = ""
-					foundPos = 0
+					s.doBeep()
 				}
+			case v == down && s.searchListRows > 0:
+				if historyPos > 0 && historyPos < len(history) {
+					historyPos--
+					show()
+				} else {
+					s.doBeep()
+				}
+			default:
+				return []rune(foundLine), foundPos, next, err
 			}
-		case action:
-			return []rune(foundLine), foundPos, next, err
 		}
-		err = s.refresh(getLine())
+		err = refresh()
 		if err != nil {
 			return []rune(foundLine), foundPos, rune(esc), err
 		}
@@ -626,6 +765,54 @@ func (s *State) PromptWithSuggestionReverse(prompt string, text string, pos int)
 	return s.doPrompt(prompt, text, pos, true)
 }
 
+// PasswordPrompt displays p, and then waits for user input. The input typed by
+// the user is not displayed in the terminal.
+func (s *State) PasswordPrompt(prompt string) (string, error) {
+	for _, r := range prompt {
+		if unicode.Is(unicode.C, r) {
+			return "", ErrInvalidPrompt
+		}
+	}
+	if s.inputRedirected || !s.terminalSupported {
+		return s.promptUnsupported(prompt)
+	}
+	if s.outputRedirected {
+		return "", ErrNotTerminalOutput
+	}
+
+	defer s.stopPrompt()
+	s.startPrompt()
+
+	fmt.Print(prompt)
+	var line []rune
+	for {
+		next, err := s.readNext()
+		if err != nil {
+			return "", err
+		}
+		v, ok := next.(rune)
+		if !ok {
+			continue
+		}
+		switch v {
+		case cr, lf:
+			fmt.Println()
+			return string(line), nil
+		case ctrlC:
+			fmt.Println("^C")
+			return "", ErrPromptAborted
+		case ctrlH, bs:
+			if len(line) > 0 {
+				line = line[:len(line)-1]
+			}
+		default:
+			if unicode.IsPrint(v) {
+				line = append(line, v)
+			}
+		}
+	}
+}
+
 func (s *State) doPrompt(prompt string, text string, pos int, reverseSearch bool) (string, error) {
 	for _, r := range prompt {
 		if unicode.Is(unicode.C, r) {
@@ -1015,18 +1202,7 @@ mainLoop:
 			case altBs: // Erase word
 				pos, line, killAction = s.eraseWord(pos, line, killAction)
 			case winch: // Window change
-				if s.multiLineMode {
-					if s.maxRows-s.cursorRows > 0 {
-						s.moveDown(s.maxRows - s.cursorRows)
-					}
-					for i := 0; i < s.maxRows-1; i++ {
-						s.cursorPos(0)
-						s.eraseLine()
-						s.moveUp(1)
-					}
-					s.maxRows = 1
-					s.cursorRows = 1
-				}
+				s.eraseMultiLine()
 			}
 			s.needRefresh = true
 		}
@@ -25,6 +25,10 @@ func (s *State) eraseLine() {
 	fmt.Print("\x1b[0K")
 }
 
+func (s *State) eraseRemainingScreen() {
+	fmt.Print("\x1b[J")
+}
+
 func (s *State) eraseScreen() {
 	fmt.Print("\x1b[H\x1b[2J")
 }
@@ -0,0 +1,133 @@
+package liner
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// HistoryItem holds the details of a history line which are shown in the
+// candidate list of the reverse search.
+type HistoryItem struct {
+	Line    string
+	Workdir string
+	Time    time.Time
+	Retval  int
+}
+
+// SetSearchListRows sets how many matches the reverse search lists below the
+// prompt. The default is 0, which disables the list and only shows the
+// current match on the prompt.
+func (s *State) SetSearchListRows(rows int) {
+	s.searchListRows = rows
+}
+
+// SetSearchMode sets the mode (ModeGlobal or ModeWorkdir) the reverse search
+// starts in. The default is ModeGlobal.
+func (s *State) SetSearchMode(mode int) {
+	s.searchMode = mode
+}
+
+func formatAge(t time.Time) string {
+	age := time.Since(t)
+	switch {
+	case t.Unix() <= 0:
+		return "?"
+	case age < time.Minute:
+		return fmt.Sprintf("%ds", int(age/time.Second))
+	case age < time.Hour:
+		return fmt.Sprintf("%dm", int(age/time.Minute))
+	case age < 24*time.Hour:
+		return fmt.Sprintf("%dh", int(age/time.Hour))
+	case age < 365*24*time.Hour:
+		return fmt.Sprintf("%dd", int(age/(24*time.Hour)))
+	}
+	return fmt.Sprintf("%dy", int(age/(365*24*time.Hour)))
+}
+
+func shortenWorkdir(dir string, max int) string {
+	r := []rune(dir)
+	if len(r) <= max {
+		return dir
+	}
+	return "…" + string(r[len(r)-max+1:])
+}
+
+// escapeControl escapes newlines, tabs and other control characters, which
+// would move the cursor and break the layout of the list.
+func escapeControl(line string) string {
+	var sb strings.Builder
+	for _, r := range line {
+		switch {
+		case r == '\t':
+			sb.WriteString(`\t`)
+		case r == '\n':
+			sb.WriteString(`\n`)
+		case r == '\r':
+			sb.WriteString(`\r`)
+		case unicode.IsControl(r):
+			fmt.Fprintf(&sb, `\x%02x`, r)
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
+
+func (s *State) formatSearchListRow(line string, selected bool) string {
+	marker := "  "
+	if selected {
+		marker = "> "
+	}
+	age, retval, workdir := "", "", ""
+	if item, ok := s.historyProvider.GetHistoryItem(line); ok {
+		age = formatAge(item.Time)
+		retval = fmt.Sprintf("%d", item.Retval)
+		if item.Retval == -9001 {
+			retval = "?"
+		}
+		workdir = shortenWorkdir(escapeControl(item.Workdir), 24)
+	}
+	row := fmt.Sprintf("%s%4s %4s  %-24s  %s", marker, age, retval, workdir, escapeControl(line))
+	return string(getPrefixGlyphs([]rune(row), s.columns-1))
+}
+
+// drawSearchList renders the matches history[top], history[top-1], ... below
+// the prompt, marking history[selected]. Afterwards, the cursor is moved back
+// to the row it was in; the caller has to refresh the prompt to restore the
+// column.
+func (s *State) drawSearchList(history []string, top int, selected int) {
+	down := 0
+	if s.multiLineMode && s.maxRows-s.cursorRows > 0 {
+		down = s.maxRows - s.cursorRows
+		s.moveDown(down)
+	}
+	rows := 0
+	for i := top; i >= 0 && rows < s.searchListRows; i-- {
+		fmt.Print("\r\n")
+		s.eraseLine()
+		row := s.formatSearchListRow(history[i], i == selected)
+		if i == selected {
+			row = "\x1b[7m" + row + "\x1b[0m"
+		}
+		fmt.Print(row)
+		rows++
+	}
+	s.eraseRemainingScreen()
+	if rows+down > 0 {
+		s.moveUp(rows + down)
+	}
+}
+
+// eraseSearchList erases the candidate list below the prompt.
+func (s *State) eraseSearchList() {
+	down := 0
+	if s.multiLineMode && s.maxRows-s.cursorRows > 0 {
+		down = s.maxRows - s.cursorRows
+		s.moveDown(down)
+	}
+	fmt.Print("\r\n")
+	s.eraseRemainingScreen()
+	s.moveUp(down + 1)
+}
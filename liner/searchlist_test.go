@@ -0,0 +1,21 @@
+package liner
+
+import "testing"
+
+func TestEscapeControl(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"git status", "git status"},
+		{"for i in 1 2\ndo echo $i\ndone", `for i in 1 2\ndo echo $i\ndone`},
+		{"printf 'a\tb'", `printf 'a\tb'`},
+		{"echo \x1b[31mred\r", `echo \x1b[31mred\r`},
+		{"echo über", "echo über"},
+	}
+	for _, test := range tests {
+		if got := escapeControl(test.in); got != test.want {
+			t.Errorf("escapeControl(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
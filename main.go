@@ -1,9 +1,11 @@
 package main
 
 import (
-	"bufio"
 	"container/list"
+	"crypto/rand"
 	"database/sql"
+	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
@@ -13,6 +15,7 @@ import (
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"hs9001/liner"
 
@@ -28,22 +31,30 @@ type HistoryEntry struct {
 	user      string
 	retval    int
 	timestamp time.Time
+	duration  int
+	session   string
+	source    string // where the entry came from, e.g. live, zsh_history
+	uuid      string // identifies the entry across databases
+}
+
+type SessionInfo struct {
+	id       string
+	hostname string
+	start    time.Time
+	end      time.Time
+	count    int
 }
 
 var GitTag string
 var GitCommit string
 
 func databaseLocation() string {
-	envOverride := os.Getenv("HS9001_DB_PATH")
-	if envOverride != "" {
-		return envOverride
-	}
-	return filepath.Join(xdgOrFallback("XDG_DATA_HOME", filepath.Join(os.Getenv("HOME"), ".local/share")), "hs9001/db.sqlite")
+	return config.dbPath
 }
 
-func createConnection() *sql.DB {
+func createConnection(path string) *sql.DB {
 
-	db, err := sql.Open("sqlite", databaseLocation())
+	db, err := sql.Open("sqlite", path)
 	if err != nil {
 		log.Panic(err)
 	}
@@ -51,6 +62,27 @@ func createConnection() *sql.DB {
 	return db
 }
 
+// openDatabase connects to the database at path, creating and migrating it
+// if necessary.
+func openDatabase(path string) *sql.DB {
+	var conn *sql.DB
+	ok, _ := exists(path)
+
+	if !ok {
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			log.Panic(err)
+		}
+		conn = createConnection(path)
+		initDatabase(conn)
+	} else {
+		conn = createConnection(path)
+	}
+
+	migrateDatabase(conn, fetchDBVersion(conn))
+	return conn
+}
+
 func initDatabase(conn *sql.DB) {
 	queryStmt := "CREATE TABLE history(id INTEGER PRIMARY KEY, command varchar(512), timestamp datetime DEFAULT current_timestamp, user varchar(25), hostname varchar(32));\n" +
 		"CREATE VIEW count_by_date AS SELECT COUNT(id), STRFTIME('%Y-%m-%d', timestamp)  FROM history GROUP BY strftime('%Y-%m-%d', timestamp)"
@@ -71,6 +103,20 @@ func migrateDatabase(conn *sql.DB, currentVersion int) {
 		"DROP VIEW count_by_date",
 		"ALTER TABLE history DROP COLUMN timestamp",
 		"ALTER TABLE history RENAME COLUMN unix_tmp TO timestamp",
+		"ALTER TABLE history ADD COLUMN duration integer DEFAULT -1",
+		"ALTER TABLE history ADD COLUMN session varchar(32) DEFAULT ''",
+		"CREATE VIRTUAL TABLE history_fts USING fts5(command, content='history', content_rowid='id', tokenize='trigram')",
+		"CREATE TRIGGER history_fts_insert AFTER INSERT ON history BEGIN INSERT INTO history_fts(rowid, command) VALUES (new.id, new.command); END",
+		"CREATE TRIGGER history_fts_delete AFTER DELETE ON history BEGIN INSERT INTO history_fts(history_fts, rowid, command) VALUES ('delete', old.id, old.command); END",
+		"CREATE TRIGGER history_fts_update AFTER UPDATE OF command ON history BEGIN INSERT INTO history_fts(history_fts, rowid, command) VALUES ('delete', old.id, old.command); INSERT INTO history_fts(rowid, command) VALUES (new.id, new.command); END",
+		"INSERT INTO history_fts(history_fts) VALUES ('rebuild')",
+		"ALTER TABLE history ADD COLUMN source varchar(64) DEFAULT ''",
+		"UPDATE history SET source = CASE WHEN workdir = '' AND retval = -9001 THEN 'import' ELSE 'live' END",
+		"ALTER TABLE history ADD COLUMN uuid varchar(36) DEFAULT ''",
+		// Random (version 4) UUIDs for the existing entries
+		"UPDATE history SET uuid = lower(hex(randomblob(4)) || '-' || hex(randomblob(2)) || '-4' || substr(hex(randomblob(2)), 2) || '-' || substr('89ab', 1 + abs(random()) % 4, 1) || substr(hex(randomblob(2)), 2) || '-' || hex(randomblob(6)))",
+		"CREATE UNIQUE INDEX history_uuid ON history(uuid)",
+		"CREATE TABLE settings(key varchar(64) PRIMARY KEY, value text)",
 	}
 
 	if !(len(migrations) > currentVersion) {
@@ -116,6 +162,31 @@ func setDBVersion(conn *sql.DB, ver int) {
 	}
 }
 
+// getSetting returns a value of the settings table, which stores properties of
+// the database itself, or "" if it is not set.
+func getSetting(conn *sql.DB, key string) string {
+	rows, err := conn.Query("SELECT value FROM settings WHERE key = ?", key)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer rows.Close()
+	var value string
+	if rows.Next() {
+		err = rows.Scan(&value)
+		if err != nil {
+			log.Panic(err)
+		}
+	}
+	return value
+}
+
+func setSetting(conn *sql.DB, key string, value string) {
+	_, err := conn.Exec("INSERT OR REPLACE INTO settings (key, value) VALUES (?, ?)", key, value)
+	if err != nil {
+		log.Panic(err)
+	}
+}
+
 func NewHistoryEntry(cmd string, retval int) HistoryEntry {
 	wd, err := os.Getwd()
 	if err != nil {
@@ -132,52 +203,265 @@ func NewHistoryEntry(cmd string, retval int) HistoryEntry {
 		cwd:       wd,
 		timestamp: time.Now(),
 		retval:    retval,
+		duration:  -1,
+		session:   os.Getenv("HS9001_SESSION"),
+		source:    "live",
+		uuid:      newUUID(),
 	}
 }
 
-func importFromStdin(conn *sql.DB) {
-	scanner := bufio.NewScanner(os.Stdin)
+type searchopts struct {
+	command     *string
+	substring   *string
+	match       *string
+	regex       *regexp.Regexp
+	workdir     *string
+	after       *time.Time
+	before      *time.Time
+	retval      *int
+	minDuration *int
+	maxDuration *int
+	session     *string
+	source      *string
+	uuid        *string
+	hostname    *string
+	failed      bool
+	order       *string
+	limit       *int
+	offset      *int // number of matching entries skipped
+}
 
-	_, err := conn.Exec("BEGIN;")
-	if err != nil {
-		log.Panic(err)
+// setSubstring restricts the search to commands containing substr.
+func (opts *searchopts) setSubstring(substr string) {
+	opts.substring = &substr
+}
+
+// filterFlags are the flags of all subcommands which operate on a filtered
+// subset of the history.
+type filterFlags struct {
+	workDir     string
+	afterTime   string
+	beforeTime  string
+	today       bool
+	retVal      int
+	minDuration time.Duration
+	maxDuration time.Duration
+	session     string
+	thisSession bool
+	source      string
+	uuid        string
+	hostname    string
+	failed      bool
+	match       string
+	regex       string
+}
+
+func addFilterFlags(fs *flag.FlagSet) *filterFlags {
+	f := &filterFlags{}
+	fs.StringVar(&f.workDir, "cwd", "", "Search only within this workdir")
+	fs.StringVar(&f.afterTime, "after", "", "Start searching from this timeframe")
+	fs.StringVar(&f.beforeTime, "before", "", "End searching from this timeframe")
+	fs.BoolVar(&f.today, "today", false, "Search only today's entries. Overrides --after")
+	fs.IntVar(&f.retVal, "ret", -9001, "Only query commands that returned with this exit code. -9001=all (default)")
+	fs.BoolVar(&f.failed, "failed", false, "Only query commands that returned with a non-zero exit code")
+	fs.StringVar(&f.hostname, "host", "", "Only query commands run on this host")
+	fs.DurationVar(&f.minDuration, "min-duration", 0, "Only query commands that ran at least this long (e.g. 30s, 5m)")
+	fs.DurationVar(&f.maxDuration, "max-duration", 0, "Only query commands that ran at most this long (e.g. 30s, 5m)")
+	fs.StringVar(&f.session, "session", "", "Search only within this shell session (see 'sessions' subcommand)")
+	fs.BoolVar(&f.thisSession, "this-session", false, "Search only within the current shell session. Overrides --session")
+	fs.StringVar(&f.source, "source", "", "Search only entries from this source, e.g. live, bash_history or sync:<host>")
+	fs.StringVar(&f.uuid, "uuid", "", "Only query the entry with this UUID")
+	fs.StringVar(&f.regex, "regex", "", "Only query commands matching this regular expression (Go syntax), e.g. 'kubectl .* -n prod'")
+	fs.StringVar(&f.match, "match", "", "Full-text query in SQLite FTS5 syntax, e.g. 'git AND push', 'kube*' or 'docker NOT compose'")
+	return f
+}
+
+// parseTime accepts dates like 2006-01-02 (15:04) as well as expressions like
+// "yesterday" or "2 weeks ago".
+func parseTime(s string) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02", "2006-01-02 15:04", "2006-01-02T15:04", "2006-01-02 15:04:05", time.RFC3339} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
 	}
+	return naturaldate.Parse(s, time.Now())
+}
 
-	for scanner.Scan() {
-		entry := NewHistoryEntry(scanner.Text(), -9001)
-		entry.cwd = ""
-		entry.timestamp = time.Unix(0, 0)
-		add(conn, entry)
+// checkMatch runs a full-text query once, so syntax errors are reported
+// instead of failing the search.
+func checkMatch(conn *sql.DB, query string) error {
+	rows, err := conn.Query("SELECT rowid FROM history_fts WHERE history_fts MATCH ? LIMIT 1", query)
+	if err == nil {
+		for rows.Next() {
+		}
+		err = rows.Err()
+		rows.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("Invalid full-text query: %s", err.Error())
 	}
+	return nil
+}
 
-	_, err = conn.Exec("END;")
+// searchopts converts the flags into search options. query is matched as a
+// substring of the commands, if it is not empty. Invalid flags terminate the
+// program.
+func (f *filterFlags) searchopts(conn *sql.DB, query string) searchopts {
+	opts, err := f.parse(conn, query)
 	if err != nil {
-		log.Panic(err)
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		os.Exit(1)
 	}
+	return opts
 }
 
-type searchopts struct {
-	command *string
-	workdir *string
-	after   *time.Time
-	before  *time.Time
-	retval  *int
-	order   *string
-	limit   *int
+// parse is searchopts, but returns an error for invalid flags.
+func (f *filterFlags) parse(conn *sql.DB, query string) (searchopts, error) {
+	opts := searchopts{}
+	if query != "" {
+		opts.setSubstring(query)
+	}
+	if f.match != "" {
+		if encryption != nil {
+			return opts, fmt.Errorf("Full-text queries are not supported for encrypted databases")
+		}
+		if err := checkMatch(conn, f.match); err != nil {
+			return opts, err
+		}
+		opts.match = &f.match
+	}
+	if f.regex != "" {
+		rgx, err := regexp.Compile(f.regex)
+		if err != nil {
+			return opts, fmt.Errorf("Failed to parse regular expression: %s", err.Error())
+		}
+		opts.regex = rgx
+	}
+	if f.workDir != "" {
+		wd, err := filepath.Abs(f.workDir)
+		if err != nil {
+			return opts, fmt.Errorf("Failed parse working directory path: %s", err.Error())
+		}
+		opts.workdir = &wd
+	}
+
+	afterTime := f.afterTime
+	if f.today {
+		afterTime = "today"
+	}
+
+	if afterTime != "" {
+		afterTimestamp, err := parseTime(afterTime)
+		if err != nil {
+			return opts, fmt.Errorf("Failed to convert time string: %s", err.Error())
+		}
+		opts.after = &afterTimestamp
+	}
+	if f.beforeTime != "" {
+		beforeTimestamp, err := parseTime(f.beforeTime)
+		if err != nil {
+			return opts, fmt.Errorf("Failed to convert time string: %s", err.Error())
+		}
+		opts.before = &beforeTimestamp
+	}
+	if f.retVal != -9001 {
+		opts.retval = &f.retVal
+	}
+	session := f.session
+	if f.thisSession {
+		session = os.Getenv("HS9001_SESSION")
+		if session == "" {
+			return opts, fmt.Errorf("HS9001_SESSION is not set, is the shell integration enabled?")
+		}
+	}
+	if session != "" {
+		opts.session = &session
+	}
+	if f.source != "" {
+		opts.source = &f.source
+	}
+	if f.uuid != "" {
+		opts.uuid = &f.uuid
+	}
+	if f.hostname != "" {
+		opts.hostname = &f.hostname
+	}
+	opts.failed = f.failed
+	if f.minDuration > 0 {
+		secs := int(f.minDuration / time.Second)
+		opts.minDuration = &secs
+	}
+	if f.maxDuration > 0 {
+		secs := int(f.maxDuration / time.Second)
+		opts.maxDuration = &secs
+	}
+	return opts, nil
+}
+
+// likeRegexp converts a LIKE pattern into an equivalent regular expression.
+func likeRegexp(pattern string) *regexp.Regexp {
+	var sb strings.Builder
+	sb.WriteString("(?is)^")
+	for _, r := range pattern {
+		switch r {
+		case '%':
+			sb.WriteString(".*")
+		case '_':
+			sb.WriteString(".")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	sb.WriteString("$")
+	return regexp.MustCompile(sb.String())
 }
 
 func search(conn *sql.DB, opts searchopts) list.List {
 	args := make([]interface{}, 0)
+	// Filters on the command which can only be applied after decryption
+	var filters []*regexp.Regexp
+	if opts.regex != nil {
+		filters = append(filters, opts.regex)
+	}
 	var sb strings.Builder
-	sb.WriteString("SELECT id, command, workdir, user, hostname, retval, timestamp ")
+	sb.WriteString("SELECT id, command, workdir, user, hostname, retval, timestamp, duration, session, source, uuid ")
 	sb.WriteString("FROM history ")
 	sb.WriteString("WHERE 1=1 ") //1=1 so we can append as many AND foo as we want, or none
 
-	if opts.command != nil {
+	if opts.command != nil && encryption != nil {
+		filters = append(filters, likeRegexp(*opts.command))
+	} else if opts.command != nil {
 		sb.WriteString("AND command LIKE ? ")
 		args = append(args, opts.command)
 	}
-	if opts.workdir != nil {
+	if opts.substring != nil {
+		// The full-text index is used whenever possible, as the trigram
+		// tokenizer can only look up substrings which are at least three
+		// characters long. It is of no use for encrypted databases.
+		substr := *opts.substring
+		switch {
+		case encryption != nil:
+			filters = append(filters, likeRegexp("%"+substr+"%"))
+		case utf8.RuneCountInString(substr) < 3 || strings.Contains(substr, "%"):
+			sb.WriteString("AND command LIKE ? ")
+			args = append(args, "%"+substr+"%")
+		default:
+			sb.WriteString("AND id IN (SELECT rowid FROM history_fts WHERE history_fts MATCH ?) ")
+			args = append(args, `"`+strings.ReplaceAll(substr, `"`, `""`)+`"`)
+		}
+	}
+	if opts.match != nil {
+		if encryption != nil {
+			log.Panic("Full-text queries are not supported for encrypted databases")
+		}
+		sb.WriteString("AND id IN (SELECT rowid FROM history_fts WHERE history_fts MATCH ?) ")
+		args = append(args, opts.match)
+	}
+	if opts.workdir != nil && encryption != nil {
+		// Equal directories are encrypted to equal values
+		sb.WriteString("AND workdir = ? ")
+		args = append(args, sealColumn(*opts.workdir))
+	} else if opts.workdir != nil {
 		sb.WriteString("AND workdir LIKE ? ")
 		args = append(args, opts.workdir)
 	}
@@ -193,18 +477,53 @@ func search(conn *sql.DB, opts searchopts) list.List {
 		sb.WriteString("AND retval = ? ")
 		args = append(args, opts.retval)
 	}
-	sb.WriteString("ORDER BY timestamp ")
+	if opts.minDuration != nil {
+		sb.WriteString("AND duration >= ? ")
+		args = append(args, opts.minDuration)
+	}
+	if opts.maxDuration != nil {
+		sb.WriteString("AND duration >= 0 AND duration <= ? ")
+		args = append(args, opts.maxDuration)
+	}
+	if opts.session != nil {
+		sb.WriteString("AND session = ? ")
+		args = append(args, opts.session)
+	}
+	if opts.source != nil {
+		sb.WriteString("AND source = ? ")
+		args = append(args, opts.source)
+	}
+	if opts.uuid != nil {
+		sb.WriteString("AND uuid = ? ")
+		args = append(args, opts.uuid)
+	}
+	if opts.hostname != nil {
+		sb.WriteString("AND hostname = ? ")
+		args = append(args, opts.hostname)
+	}
+	if opts.failed {
+		sb.WriteString("AND retval != 0 AND retval != -9001 ")
+	}
+	order := "ASC"
 	if opts.order != nil {
-		sb.WriteString(*opts.order)
-		sb.WriteRune(' ')
-	} else {
-		sb.WriteString("ASC ")
+		order = *opts.order
 	}
-
-	if opts.limit != nil {
+	// Commands entered within the same second are ordered by insertion
+	sb.WriteString("ORDER BY timestamp " + order + ", id " + order + " ")
+
+	// The filters are applied after fetching the rows, so the limit can only
+	// be enforced afterwards as well
+	skip := 0
+	if opts.offset != nil {
+		skip = *opts.offset
+	}
+	if opts.limit != nil && len(filters) == 0 {
 		sb.WriteString("LIMIT ")
 		sb.WriteString(strconv.Itoa(*opts.limit))
+		sb.WriteString(" OFFSET ")
+		sb.WriteString(strconv.Itoa(skip))
 		sb.WriteRune(' ')
+		skip = 0
 	}
 
 	queryStmt := sb.String()
@@ -219,16 +538,83 @@ func search(conn *sql.DB, opts searchopts) list.List {
 	for rows.Next() {
 		var entry HistoryEntry
 		var timestamp int64
-		err = rows.Scan(&entry.id, &entry.cmd, &entry.cwd, &entry.user, &entry.hostname, &entry.retval, &timestamp)
+		err = rows.Scan(&entry.id, &entry.cmd, &entry.cwd, &entry.user, &entry.hostname, &entry.retval, &timestamp, &entry.duration, &entry.session, &entry.source, &entry.uuid)
 		if err != nil {
 			log.Panic(err)
 		}
+		entry.cmd = openColumn(entry.cmd)
+		entry.cwd = openColumn(entry.cwd)
+		if !matchesAll(filters, entry.cmd) {
+			continue
+		}
+		if skip > 0 {
+			skip--
+			continue
+		}
 		entry.timestamp = time.Unix(timestamp, 0)
 		result.PushBack(&entry)
+		if opts.limit != nil && result.Len() >= *opts.limit {
+			break
+		}
+	}
+	return result
+}
+
+func matchesAll(filters []*regexp.Regexp, cmd string) bool {
+	for _, f := range filters {
+		if !f.MatchString(cmd) {
+			return false
+		}
+	}
+	return true
+}
+
+func sessions(conn *sql.DB) []SessionInfo {
+	queryStmt := "SELECT session, hostname, MIN(timestamp), MAX(timestamp), COUNT(id) FROM history WHERE session != '' GROUP BY session ORDER BY MIN(timestamp) ASC"
+
+	rows, err := conn.Query(queryStmt)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer rows.Close()
+
+	var result []SessionInfo
+	for rows.Next() {
+		var info SessionInfo
+		var start, end int64
+		err = rows.Scan(&info.id, &info.hostname, &start, &end, &info.count)
+		if err != nil {
+			log.Panic(err)
+		}
+		info.start = time.Unix(start, 0)
+		info.end = time.Unix(end, 0)
+		result = append(result, info)
 	}
 	return result
 }
 
+// newUUID returns a random (version 4) UUID.
+func newUUID() string {
+	buf := make([]byte, 16)
+	_, err := rand.Read(buf)
+	if err != nil {
+		log.Panic(err)
+	}
+	buf[6] = (buf[6] & 0x0f) | 0x40
+	buf[8] = (buf[8] & 0x3f) | 0x80
+	h := hex.EncodeToString(buf)
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:32]
+}
+
+func newSessionId() string {
+	buf := make([]byte, 8)
+	_, err := rand.Read(buf)
+	if err != nil {
+		log.Panic(err)
+	}
+	return hex.EncodeToString(buf)
+}
+
 func delete(conn *sql.DB, entryId uint32) {
 	queryStmt := "DELETE FROM history WHERE id = ?"
 
@@ -238,17 +624,27 @@ func delete(conn *sql.DB, entryId uint32) {
 	}
 }
 
-func add(conn *sql.DB, entry HistoryEntry) {
-	stmt, err := conn.Prepare("INSERT INTO history (user, command, hostname, workdir, timestamp, retval) VALUES (?, ?, ?, ?, ?,?)")
+// prepareAdd prepares the statement used by addPrepared, which the caller has
+// to close. It saves preparing it again for every entry of a batch.
+func prepareAdd(conn *sql.DB) *sql.Stmt {
+	stmt, err := conn.Prepare("INSERT INTO history (user, command, hostname, workdir, timestamp, retval, duration, session, source, uuid) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
 	if err != nil {
 		log.Panic(err)
 	}
+	return stmt
+}
 
-	_, err = stmt.Exec(entry.user, entry.cmd, entry.hostname, entry.cwd, entry.timestamp.Unix(), entry.retval)
+func addPrepared(stmt *sql.Stmt, entry HistoryEntry) {
+	_, err := stmt.Exec(entry.user, sealColumn(entry.cmd), entry.hostname, sealColumn(entry.cwd), entry.timestamp.Unix(), entry.retval, entry.duration, entry.session, entry.source, entry.uuid)
 	if err != nil {
 		log.Panic(err)
 	}
+}
 
+func add(conn *sql.DB, entry HistoryEntry) {
+	stmt := prepareAdd(conn)
+	defer stmt.Close()
+	addPrepared(stmt, entry)
 }
 
 func xdgOrFallback(xdg string, fallback string) string {
@@ -274,8 +670,70 @@ func exists(path string) (bool, error) {
 	return false, err
 }
 
+// parseAddArgs builds the entry to be added from the arguments of the add
+// subcommand. It returns false if nothing should be recorded.
+func parseAddArgs(addCmd *flag.FlagSet, globalargs []string) (HistoryEntry, bool) {
+	var ret int
+	var start int64
+	var raw bool
+	addCmd.IntVar(&ret, "ret", 0, "Return value of the command to add")
+	addCmd.Int64Var(&start, "start", 0, "Unix timestamp at which the command was started, used to calculate its duration")
+	addCmd.BoolVar(&raw, "raw", false, "Command is given as-is instead of in the format of bash's 'history 1'")
+	addCmd.Parse(globalargs)
+	args := addCmd.Args()
+
+	if ret == 23 { // 23 is our secret do not log status code
+		return HistoryEntry{}, false
+	}
+	if len(args) < 1 {
+		fmt.Fprint(os.Stderr, "Error: You need to provide the command to be added")
+		return HistoryEntry{}, false
+	}
+	historycmd := args[0]
+	if !raw {
+		// bash prints the history number, a '*' if the entry has been modified and a space
+		var rgx = regexp.MustCompile(`^\s*\d+[ *] (.*)`)
+		rs := rgx.FindStringSubmatch(historycmd)
+		if len(rs) != 2 {
+			return HistoryEntry{}, false
+		}
+		historycmd = rs[1]
+	}
+	entry := NewHistoryEntry(historycmd, ret)
+	ignore := parseIgnoreRules(config.ignore)
+	if ignore.ignored(entry.cmd, entry.cwd) {
+		return HistoryEntry{}, false
+	}
+	redactor := newRedactor(config.redact)
+	entry.cmd = redactor.redact(strings.TrimLeft(entry.cmd, " \t"))
+	if start > 0 && start <= entry.timestamp.Unix() {
+		entry.duration = int(entry.timestamp.Unix() - start)
+	}
+	return entry, true
+}
+
+// reverseSearch runs the CTRL-R prompt, the accepted command is printed to
+// stderr.
+func reverseSearch(h *history) {
+	line := liner.NewLiner()
+	defer line.Close()
+	line.SetCtrlCAborts(true)
+	line.SetHistoryProvider(h)
+	line.SetMultiLineMode(true)
+	line.SetSearchListRows(config.ctrlrListRows)
+	line.SetSearchMode(config.ctrlrMode)
+
+	rdlineline := os.Getenv("READLINE_LINE")
+	rdlinepos := os.Getenv("READLINE_POS")
+	rdlineposint, _ := strconv.Atoi(rdlinepos)
+
+	if name, err := line.PromptWithSuggestionReverse("", rdlineline, rdlineposint); err == nil {
+		fmt.Fprintf(os.Stderr, "%s\n", name)
+	}
+}
+
 func printUsage() {
-	fmt.Fprintf(os.Stderr, "Usage:   ./hs9001 <add/search/import/nolog/bash-enable>\n")
+	fmt.Fprintf(os.Stderr, "Usage:   ./hs9001 <add/search/sessions/stats/import/export/sync/redact/rekey/unlock/lock/daemon/serve/config/nolog/bash-enable/zsh-enable/fish-enable>\n")
 }
 
 func main() {
@@ -290,158 +748,286 @@ func main() {
 	cmd := os.Args[1]
 	globalargs := os.Args[2:]
 
-	var conn *sql.DB
-	ok, _ := exists(databaseLocation())
+	config = loadConfig()
 
-	if !ok {
-		err := os.MkdirAll(filepath.Dir(databaseLocation()), 0755)
-		if err != nil {
-			log.Panic(err)
+	// The daemon, if it is running, saves opening the database for the
+	// commands run at every prompt
+	var newEntry HistoryEntry
+	switch cmd {
+	case "add":
+		var ok bool
+		newEntry, ok = parseAddArgs(addCmd, globalargs)
+		if !ok {
+			return
+		}
+		if client, err := dialDaemon(); err == nil {
+			err = client.add(newEntry)
+			client.Close()
+			if err == nil {
+				return
+			}
+		}
+	case "bash-ctrlr":
+		if client, err := dialDaemon(); err == nil {
+			defer client.Close()
+			reverseSearch(&history{daemon: client})
+			return
 		}
-		conn = createConnection()
-		initDatabase(conn)
-	} else {
-		conn = createConnection()
 	}
 
-	migrateDatabase(conn, fetchDBVersion(conn))
+	conn := openDatabase(databaseLocation())
 
 	switch cmd {
-	case "bash-ctrlr":
-		line := liner.NewLiner()
-		defer line.Close()
-		line.SetCtrlCAborts(true)
-		line.SetHistoryProvider(&history{conn: conn})
-		line.SetMultiLineMode(true)
-
-		rdlineline := os.Getenv("READLINE_LINE")
-		rdlinepos := os.Getenv("READLINE_POS")
-		rdlineposint, _ := strconv.Atoi(rdlinepos)
-
-		if name, err := line.PromptWithSuggestionReverse("", rdlineline, rdlineposint); err == nil {
-			fmt.Fprintf(os.Stderr, "%s\n", name)
+	case "bash-enable", "bash-disable", "zsh-enable", "zsh-disable", "fish-enable", "fish-disable", "config", "version", "unlock", "lock", "agent":
+		// No access to encrypted columns
+	default:
+		flushDaemon()
+		err := loadEncryption(conn)
+		if errors.Is(err, errLocked) && cmd == "add" {
+			// Recorded once the database is unlocked
+			err = queueEntry(newEntry)
+			if err == nil {
+				return
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+			os.Exit(1)
+		}
+		if _, err = addQueued(conn); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to add queued entries: %s\n", err.Error())
 		}
+	}
 
+	switch cmd {
+	case "bash-ctrlr":
+		reverseSearch(&history{conn: conn})
 	case "bash-enable":
 		fmt.Printf(`
 			if [ -n "$PS1" ] ; then
-				PROMPT_COMMAND='hs9001 add -ret $? "$(history 1)"'
+				export HS9001_SESSION=%s
+				case "$(trap -p DEBUG)" in
+					*_hs9001_start*) ;;
+					*)
+						# An existing trap, e.g. of bash-preexec, keeps running before ours
+						_hs9001_prev_debug_trap="$(trap -p DEBUG)"
+						_hs9001_trap_command() { printf '%%s' "$3"; }
+						_hs9001_prev_debug_cmd="$(eval "_hs9001_trap_command $_hs9001_prev_debug_trap")"
+						unset -f _hs9001_trap_command
+						;;
+				esac
+				if [ "${BASH_VERSINFO[0]}" -gt 4 ] || { [ "${BASH_VERSINFO[0]}" -eq 4 ] && [ "${BASH_VERSINFO[1]}" -ge 2 ]; } ; then
+					_hs9001_now='printf -v _hs9001_start "%%(%%s)T" -1'
+				else
+					# printf %%(...)T needs bash 4.2, SECONDS counts from the start of the shell
+					_hs9001_epoch=$(( $(date +%%s) - SECONDS ))
+					_hs9001_now='_hs9001_start=$((_hs9001_epoch + SECONDS))'
+				fi
+				trap "${_hs9001_prev_debug_cmd:+$_hs9001_prev_debug_cmd
+				}"'[ -z "$_hs9001_start" ] && '"$_hs9001_now" DEBUG
+				PROMPT_COMMAND='_hs9001_ret=$?; _hs9001_cur="$(history 1)"; if [ -n "${_hs9001_prev+x}" ] && [ "$_hs9001_cur" != "$_hs9001_prev" ]; then hs9001 add -ret $_hs9001_ret -start "${_hs9001_start:-0}" "$_hs9001_cur"; fi; _hs9001_prev="$_hs9001_cur"; unset _hs9001_start'
 				bind -x '"\C-r": " READLINE_LINE=$(hs9001 bash-ctrlr 3>&1 1>&2 2>&3) READLINE_POINT=0"'
 			fi
 			alias hs='hs9001 search'
-		`)
+		`, newSessionId())
 	case "bash-disable":
-		fmt.Printf("unset PROMPT_COMMAND\n")
+		// Only remove the DEBUG trap if it is still ours, restoring the one
+		// which was set before bash-enable
+		fmt.Printf(`
+			unset PROMPT_COMMAND
+			case "$(trap -p DEBUG)" in
+				*_hs9001_start*)
+					if [ -n "$_hs9001_prev_debug_trap" ] ; then
+						eval "$_hs9001_prev_debug_trap"
+					else
+						trap - DEBUG
+					fi
+					;;
+			esac
+			unset _hs9001_prev_debug_trap _hs9001_prev_debug_cmd
+		`)
+	case "zsh-enable":
+		fmt.Printf(`
+			if [[ -o interactive ]] ; then
+				autoload -Uz add-zsh-hook
+				zmodload zsh/datetime
+				export HS9001_SESSION=%s
+				_hs9001_preexec() {
+					_hs9001_cmd="$1"
+					_hs9001_start=$EPOCHSECONDS
+				}
+				_hs9001_precmd() {
+					local ret=$?
+					if [[ -n "$_hs9001_cmd" ]] ; then
+						hs9001 add -raw -ret $ret -start "${_hs9001_start:-0}" "$_hs9001_cmd"
+					fi
+					unset _hs9001_cmd _hs9001_start
+				}
+				_hs9001_ctrlr() {
+					BUFFER=$(READLINE_LINE="$BUFFER" READLINE_POS="$CURSOR" hs9001 bash-ctrlr 3>&1 1>&2 2>&3 </dev/tty)
+					CURSOR=${#BUFFER}
+					zle reset-prompt
+				}
+				add-zsh-hook preexec _hs9001_preexec
+				add-zsh-hook precmd _hs9001_precmd
+				zle -N _hs9001_ctrlr
+				bindkey '^R' _hs9001_ctrlr
+			fi
+			alias hs='hs9001 search'
+		`, newSessionId())
+	case "zsh-disable":
+		fmt.Printf(`
+			add-zsh-hook -d preexec _hs9001_preexec
+			add-zsh-hook -d precmd _hs9001_precmd
+			bindkey '^R' history-incremental-search-backward
+			unalias hs
+		`)
+	case "fish-enable":
+		fmt.Printf(`
+			if status is-interactive
+				set -gx HS9001_SESSION %s
+				function _hs9001_preexec --on-event fish_preexec
+					set -g _hs9001_start (date +%%s)
+				end
+				function _hs9001_postexec --on-event fish_postexec
+					set -l ret $status
+					if test -n "$argv"
+						hs9001 add -raw -ret $ret -start "$_hs9001_start" -- "$argv"
+					end
+					set -e _hs9001_start
+				end
+				function _hs9001_ctrlr
+					set -l result (env READLINE_LINE=(commandline | string collect) READLINE_POS=(commandline -C) hs9001 bash-ctrlr 3>&1 1>&2 2>&3 </dev/tty | string collect)
+					commandline -r -- $result
+					commandline -f repaint
+				end
+				bind \cr _hs9001_ctrlr
+				bind -M insert \cr _hs9001_ctrlr
+			end
+			alias hs='hs9001 search'
+		`, newSessionId())
+	case "fish-disable":
+		fmt.Printf(`
+			functions -e _hs9001_preexec _hs9001_postexec _hs9001_ctrlr hs
+			bind -e \cr
+			bind -M insert -e \cr
+		`)
 	case "add":
-		var ret int
-		addCmd.IntVar(&ret, "ret", 0, "Return value of the command to add")
-		addCmd.Parse(globalargs)
-		args := addCmd.Args()
-
-		if ret == 23 { // 23 is our secret do not log status code
-			return
-		}
-		if len(args) < 1 {
-			fmt.Fprint(os.Stderr, "Error: You need to provide the command to be added")
-
-		}
-		historycmd := args[0]
-		var rgx = regexp.MustCompile(`\s+\d+\s+(.*)`)
-		rs := rgx.FindStringSubmatch(historycmd)
-		if len(rs) == 2 {
-			add(conn, NewHistoryEntry(rs[1], ret))
-		}
+		add(conn, newEntry)
 	case "search":
 		fallthrough
 	case "delete":
-		var workDir string
-		var afterTime string
-		var beforeTime string
 		var distinct bool = true
-		var today bool = false
-		var retVal int
-		searchCmd.StringVar(&workDir, "cwd", "", "Search only within this workdir")
-		searchCmd.StringVar(&afterTime, "after", "", "Start searching from this timeframe")
-		searchCmd.StringVar(&beforeTime, "before", "", "End searching from this timeframe")
+		var showDuration bool
+		var limit int
+		var format string
+		var fieldList string
+		var tmpl string
+		filter := addFilterFlags(searchCmd)
 		searchCmd.BoolVar(&distinct, "distinct", true, "Remove consecutive duplicate commands from output")
-		searchCmd.BoolVar(&today, "today", false, "Search only today's entries. Overrides --after")
-		searchCmd.IntVar(&retVal, "ret", -9001, "Only query commands that returned with this exit code. -9001=all (default)")
+		searchCmd.BoolVar(&showDuration, "show-duration", false, "Print the duration of each command in front of it")
+		defaultLimit := 0
+		if cmd == "search" {
+			defaultLimit = config.searchLimit
+		}
+		searchCmd.IntVar(&limit, "limit", defaultLimit, "Only query the most recent n commands. 0=all")
+		searchCmd.StringVar(&format, "format", "plain", "Output format: "+strings.Join(outputFormats, ", "))
+		searchCmd.StringVar(&tmpl, "template", "", "Go text/template executed for each entry, e.g. '{{.Time.Format \"15:04\"}} {{shortpath .Cwd}} {{.Cmd}}'. Overrides --format. Defaults to the template in the config file unless --format is given")
+		searchCmd.StringVar(&fieldList, "fields", "", "Comma separated list of fields printed by the structured formats: "+strings.Join(outputFields, ","))
+		if cmd == "search" {
+			// Default flags from the config file, which can be overridden on the command line
+			globalargs = append(config.searchFlags, globalargs...)
+		}
 		searchCmd.Parse(globalargs)
 
 		args := searchCmd.Args()
 
-		q := strings.Join(args, " ")
-
-		opts := searchopts{}
-		o := "ASC"
-		opts.order = &o
-		if q != "" {
-			cmd := "%" + q + "%"
-			opts.command = &cmd
-		}
-		if workDir != "" {
-			wd, err := filepath.Abs(workDir)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Failed parse working directory path: %s\n", err.Error())
+		if cmd == "search" {
+			// The template from the config file only replaces the default
+			// output, not an explicitly chosen format
+			explicit := false
+			searchCmd.Visit(func(f *flag.Flag) {
+				if f.Name == "format" || f.Name == "template" {
+					explicit = true
+				}
+			})
+			if !explicit {
+				tmpl = config.searchTemplate
 			}
-			opts.workdir = &wd
 		}
 
-		if today {
-			afterTime = "today"
+		if !validFormat(format) {
+			fmt.Fprintf(os.Stderr, "Error: Unknown format '%s', available formats: %s\n", format, strings.Join(outputFormats, ", "))
+			os.Exit(1)
+		}
+		fields, err := parseFields(fieldList)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+			os.Exit(1)
 		}
 
-		if afterTime != "" {
-			afterTimestamp, err := naturaldate.Parse(afterTime, time.Now())
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Failed to convert time string: %s\n", err.Error())
-			}
-			opts.after = &afterTimestamp
+		opts := filter.searchopts(conn, strings.Join(args, " "))
+		o := "ASC"
+		if limit > 0 {
+			// Fetch the most recent commands, their order is restored below
+			o = "DESC"
+			opts.limit = &limit
 		}
-		if beforeTime != "" {
-			beforeTimestamp, err := naturaldate.Parse(beforeTime, time.Now())
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Failed to convert time string: %s\n", err.Error())
+		opts.order = &o
+		results := search(conn, opts)
+		if limit > 0 {
+			var ascending list.List
+			for e := results.Front(); e != nil; e = e.Next() {
+				ascending.PushFront(e.Value)
 			}
-			opts.before = &beforeTimestamp
-		}
-		if retVal != -9001 {
-			opts.retval = &retVal
+			results = ascending
 		}
-		results := search(conn, opts)
 
 		previousCmd := ""
 		previousReturn := -1
 
-		fi, err := os.Stdout.Stat()
-		if err != nil {
-			panic(err)
-		}
-
-		//Don't print colors if output is piped
-		printColors := true
-		if (fi.Mode() & os.ModeCharDevice) == 0 {
-			printColors = false
-		}
+		printColors := config.printColors(os.Stdout)
 
+		var entries []*HistoryEntry
 		for e := results.Front(); e != nil; e = e.Next() {
 			entry, ok := e.Value.(*HistoryEntry)
 			if !ok {
 				log.Panic("Failed to retrieve entries")
 			}
-			if !distinct || !(previousCmd == entry.cmd && previousReturn == entry.retval)  {
-				prefix := ""
-				postfix := ""
-				if printColors && entry.retval != 0 {
-					prefix = "\033[38;5;88m"
-					postfix = "\033[0m"
-				}
-				fmt.Printf("%s%s%s\n", prefix, entry.cmd, postfix)
+			if !distinct || !(previousCmd == entry.cmd && previousReturn == entry.retval) {
+				entries = append(entries, entry)
 			}
 			previousCmd = entry.cmd
 			previousReturn = entry.retval
 		}
 
+		if tmpl == "" && format == "plain" {
+			tmpl = "{{statuscolor .Retval .Cmd}}"
+			if showDuration {
+				tmpl = `{{printf "%8s" (duration .Duration)}}  ` + tmpl
+			}
+		}
+		if tmpl != "" {
+			t, err := parseOutputTemplate(tmpl, printColors)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to parse template: %s\n", err.Error())
+				os.Exit(1)
+			}
+			for _, entry := range entries {
+				err = t.Execute(os.Stdout, newTemplateEntry(entry))
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Failed to execute template: %s\n", err.Error())
+					os.Exit(1)
+				}
+			}
+		} else {
+			err := printEntries(os.Stdout, format, fields, entries)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to print entries: %s\n", err.Error())
+				os.Exit(1)
+			}
+		}
+
 		if cmd == "delete" {
 
 			_, err := conn.Exec("BEGIN;")
@@ -469,8 +1055,218 @@ func main() {
 
 		}
 		os.Exit(23)
+	case "sessions":
+		for _, info := range sessions(conn) {
+			fmt.Printf("%s  %s  %s  %-20s %d\n", info.id, info.start.Format("2006-01-02 15:04:05"), info.end.Format("2006-01-02 15:04:05"), info.hostname, info.count)
+		}
+	case "redact":
+		var dryRun bool
+		redactCmd := flag.NewFlagSet("redact", flag.ExitOnError)
+		redactCmd.BoolVar(&dryRun, "dry-run", false, "Only print the redacted commands, do not modify the database")
+		redactCmd.Parse(globalargs)
+
+		changed := redactHistory(conn, newRedactor(config.redact), dryRun)
+		fmt.Fprintf(os.Stderr, "%d entries redacted\n", changed)
+	case "rekey":
+		var keyfile string
+		var decrypt bool
+		rekeyCmd := flag.NewFlagSet("rekey", flag.ExitOnError)
+		rekeyCmd.StringVar(&keyfile, "keyfile", "", "Encrypt with the contents of this file instead of a passphrase")
+		rekeyCmd.BoolVar(&decrypt, "decrypt", false, "Decrypt the database")
+		rekeyCmd.Parse(globalargs)
+
+		var key []byte
+		var salt []byte
+		keySource := ""
+		switch {
+		case decrypt:
+		case keyfile != "":
+			var err error
+			key, err = keyfileKey(keyfile)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to read keyfile: %s\n", err.Error())
+				os.Exit(1)
+			}
+			keySource = "keyfile"
+		default:
+			passphrase, err := readPassphrase("New passphrase: ", true)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+				os.Exit(1)
+			}
+			salt = newSalt()
+			key = pbkdf2([]byte(passphrase), salt, pbkdf2Iterations)
+			keySource = "passphrase"
+		}
+
+		count := rekey(conn, key, keySource, salt)
+		fmt.Fprintf(os.Stderr, "%d entries rewritten\n", count)
+		lockAgent()
+		switch keySource {
+		case "passphrase":
+			err := startAgent(key, 12*time.Hour)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to start agent: %s\n", err.Error())
+			}
+		case "keyfile":
+			if keyfile != config.keyfile {
+				fmt.Fprintf(os.Stderr, "Set 'keyfile = %s' in the [encryption] section of %s\n", keyfile, configFileLocation())
+			}
+		}
+	case "unlock":
+		var timeout time.Duration
+		unlockCmd := flag.NewFlagSet("unlock", flag.ExitOnError)
+		unlockCmd.DurationVar(&timeout, "timeout", 12*time.Hour, "Lock the database again after this time")
+		unlockCmd.Parse(globalargs)
+
+		if getSetting(conn, "key_source") != "passphrase" {
+			fmt.Fprintf(os.Stderr, "Error: The database is not encrypted with a passphrase\n")
+			os.Exit(1)
+		}
+		passphrase, err := readPassphrase("Passphrase: ", false)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+			os.Exit(1)
+		}
+		key := passphraseKey(conn, passphrase)
+		if !checkKey(conn, key) {
+			fmt.Fprintf(os.Stderr, "Error: Wrong passphrase\n")
+			os.Exit(1)
+		}
+		lockAgent()
+		err = startAgent(key, timeout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to start agent: %s\n", err.Error())
+			os.Exit(1)
+		}
+		encryption = newSealer(key)
+		if _, err = addQueued(conn); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to add queued entries: %s\n", err.Error())
+		}
+	case "lock":
+		lockAgent()
+	case "agent":
+		var timeout time.Duration
+		agentCmd := flag.NewFlagSet("agent", flag.ExitOnError)
+		agentCmd.DurationVar(&timeout, "timeout", 12*time.Hour, "Stop after this time")
+		agentCmd.Parse(globalargs)
+
+		err := runAgent(timeout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Agent failed: %s\n", err.Error())
+			os.Exit(1)
+		}
+	case "daemon":
+		var flushInterval time.Duration
+		daemonCmd := flag.NewFlagSet("daemon", flag.ExitOnError)
+		daemonCmd.DurationVar(&flushInterval, "flush-interval", time.Second, "Write new entries to the database at least this often")
+		daemonCmd.Parse(globalargs)
+
+		err := runDaemon(conn, flushInterval)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Daemon failed: %s\n", err.Error())
+			os.Exit(1)
+		}
+	case "serve":
+		var address string
+		var token string
+		var allowDelete bool
+		serveCmd := flag.NewFlagSet("serve", flag.ExitOnError)
+		serveCmd.StringVar(&address, "listen", "127.0.0.1:9001", "Address to listen on, host:port or unix:<path>")
+		serveCmd.StringVar(&token, "token", os.Getenv("HS9001_SERVE_TOKEN"), "Require this token in requests, defaults to $HS9001_SERVE_TOKEN or, unless listening on a Unix socket, a random token printed on startup")
+		serveCmd.BoolVar(&allowDelete, "allow-delete", false, "Allow deleting entries, e.g. in the web UI")
+		serveCmd.Parse(globalargs)
+
+		err := runServer(conn, address, token, allowDelete)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to serve: %s\n", err.Error())
+			os.Exit(1)
+		}
+	case "stats":
+		var top int
+		var minRuns int
+		statsCmd := flag.NewFlagSet("stats", flag.ExitOnError)
+		filter := addFilterFlags(statsCmd)
+		statsCmd.IntVar(&top, "top", 10, "Number of entries in each ranking. 0=all")
+		statsCmd.IntVar(&minRuns, "min-runs", 5, "Only report failure rates of programs run at least this often")
+		statsCmd.Parse(globalargs)
+
+		opts := filter.searchopts(conn, strings.Join(statsCmd.Args(), " "))
+		s := computeStats(search(conn, opts))
+		s.print(top, minRuns)
+		os.Exit(23)
+	case "export":
+		var format string
+		exportCmd := flag.NewFlagSet("export", flag.ExitOnError)
+		filter := addFilterFlags(exportCmd)
+		exportCmd.StringVar(&format, "format", "bash", "History file format: "+strings.Join(exportFormats, ", "))
+		exportCmd.Parse(globalargs)
+
+		opts := filter.searchopts(conn, strings.Join(exportCmd.Args(), " "))
+		err := exportHistory(os.Stdout, format, search(conn, opts))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to export history: %s\n", err.Error())
+			os.Exit(1)
+		}
+	case "sync":
+		syncCmd := flag.NewFlagSet("sync", flag.ExitOnError)
+		syncCmd.Usage = func() {
+			fmt.Fprintf(os.Stderr, "Usage: hs9001 sync <path to other database>\n")
+		}
+		syncCmd.Parse(globalargs)
+		if syncCmd.NArg() != 1 {
+			syncCmd.Usage()
+			os.Exit(1)
+		}
+
+		remote := openDatabase(syncCmd.Arg(0))
+		defer remote.Close()
+		pulled, pushed, err := syncDatabases(conn, remote)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to sync: %s\n", err.Error())
+			os.Exit(1)
+		}
+		fmt.Printf("Copied %d entries from %s and %d entries to it\n", pulled, syncCmd.Arg(0), pushed)
+	case "config":
+		config.print()
 	case "import":
-		importFromStdin(conn)
+		var format string
+		var source string
+		importCmd := flag.NewFlagSet("import", flag.ExitOnError)
+		importCmd.StringVar(&format, "format", "auto", "History file format: "+strings.Join(importFormats, ", "))
+		importCmd.StringVar(&source, "source", "", "Tag the imported entries with this source instead of <format>_history, see 'delete -source'")
+		importCmd.Parse(globalargs)
+
+		var commands []importedCommand
+		if importCmd.NArg() == 0 {
+			var err error
+			commands, err = parseHistory(os.Stdin, format)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to import history: %s\n", err.Error())
+				os.Exit(1)
+			}
+		}
+		for _, path := range importCmd.Args() {
+			f, err := os.Open(path)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to import history: %s\n", err.Error())
+				os.Exit(1)
+			}
+			c, err := parseHistory(f, format)
+			f.Close()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to import %s: %s\n", path, err.Error())
+				os.Exit(1)
+			}
+			commands = append(commands, c...)
+		}
+		if source != "" {
+			for i := range commands {
+				commands[i].source = source
+			}
+		}
+		imported := importHistory(conn, commands)
+		fmt.Printf("Imported %d commands, skipped %d already in the history\n", imported, len(commands)-imported)
 	case "version":
 		fmt.Fprintf(os.Stdout, "Git Tag: %s\nGit Commit: %s\n", GitTag, GitCommit)
 	default:
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckMatch(t *testing.T) {
+	conn := openDatabase(filepath.Join(t.TempDir(), "db.sqlite"))
+	defer conn.Close()
+	add(conn, NewHistoryEntry("git push origin main", 0))
+
+	tests := []struct {
+		query string
+		ok    bool
+	}{
+		{"push", true},
+		{"git AND (push OR pull)", true},
+		{"kube*", true},
+		{"AND(", false},
+		{`"unterminated`, false},
+		{"NOT", false},
+	}
+	for _, test := range tests {
+		if err := checkMatch(conn, test.query); (err == nil) != test.ok {
+			t.Errorf("checkMatch(%q) = %v, want ok = %v", test.query, err, test.ok)
+		}
+	}
+
+	var f filterFlags
+	f.match = "AND("
+	if _, err := f.parse(conn, ""); err == nil {
+		t.Error("parse accepted an invalid full-text query")
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"unicode"
+)
+
+var outputFields = []string{"id", "time", "command", "workdir", "hostname", "user", "retval", "duration", "session", "source", "uuid"}
+
+var outputFormats = []string{"plain", "json", "jsonl", "csv", "tsv", "table"}
+
+// parseFields validates a comma separated list of output fields. An empty
+// list selects all fields.
+func parseFields(list string) ([]string, error) {
+	if list == "" {
+		return outputFields, nil
+	}
+	var fields []string
+	for _, f := range strings.Split(list, ",") {
+		f = strings.TrimSpace(f)
+		known := false
+		for _, o := range outputFields {
+			if f == o {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown field '%s', available fields: %s", f, strings.Join(outputFields, ","))
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+func validFormat(format string) bool {
+	for _, f := range outputFormats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// fieldValue returns the value of field for entry. Unknown exit codes and
+// durations are returned as nil.
+func fieldValue(entry *HistoryEntry, field string) interface{} {
+	switch field {
+	case "id":
+		return entry.id
+	case "time":
+		return entry.timestamp.Format(time.RFC3339)
+	case "command":
+		return entry.cmd
+	case "workdir":
+		return entry.cwd
+	case "hostname":
+		return entry.hostname
+	case "user":
+		return entry.user
+	case "retval":
+		if entry.retval == -9001 {
+			return nil
+		}
+		return entry.retval
+	case "duration":
+		if entry.duration < 0 {
+			return nil
+		}
+		return entry.duration
+	case "session":
+		return entry.session
+	case "source":
+		return entry.source
+	case "uuid":
+		return entry.uuid
+	}
+	panic("Invalid field")
+}
+
+func fieldString(entry *HistoryEntry, field string) string {
+	v := fieldValue(entry, field)
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+// marshalJSON is json.Marshal without escaping of HTML characters, which are
+// common in commands
+func marshalJSON(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	err := enc.Encode(v)
+	return bytes.TrimRight(buf.Bytes(), "\n"), err
+}
+
+func jsonObject(entry *HistoryEntry, fields []string) ([]byte, error) {
+	var sb strings.Builder
+	sb.WriteRune('{')
+	for i, f := range fields {
+		if i > 0 {
+			sb.WriteRune(',')
+		}
+		key, _ := marshalJSON(f)
+		value, err := marshalJSON(fieldValue(entry, f))
+		if err != nil {
+			return nil, err
+		}
+		sb.Write(key)
+		sb.WriteRune(':')
+		sb.Write(value)
+	}
+	sb.WriteRune('}')
+	return []byte(sb.String()), nil
+}
+
+// tableCell escapes tabs, newlines and other control characters, which would
+// break the columns of the table format.
+func tableCell(s string) string {
+	var sb strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '\t':
+			sb.WriteString(`\t`)
+		case r == '\n':
+			sb.WriteString(`\n`)
+		case r == '\r':
+			sb.WriteString(`\r`)
+		case unicode.IsControl(r):
+			fmt.Fprintf(&sb, `\x%02x`, r)
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
+
+// printEntries writes entries to w in one of the structured formats.
+func printEntries(w io.Writer, format string, fields []string, entries []*HistoryEntry) error {
+	switch format {
+	case "json":
+		fmt.Fprint(w, "[")
+		for i, entry := range entries {
+			obj, err := jsonObject(entry, fields)
+			if err != nil {
+				return err
+			}
+			if i > 0 {
+				fmt.Fprint(w, ",")
+			}
+			fmt.Fprintf(w, "\n  %s", obj)
+		}
+		fmt.Fprint(w, "\n]\n")
+	case "jsonl":
+		for _, entry := range entries {
+			obj, err := jsonObject(entry, fields)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(w, "%s\n", obj)
+		}
+	case "csv", "tsv":
+		cw := csv.NewWriter(w)
+		if format == "tsv" {
+			cw.Comma = '\t'
+		}
+		cw.Write(fields)
+		for _, entry := range entries {
+			record := make([]string, len(fields))
+			for i, f := range fields {
+				record[i] = fieldString(entry, f)
+			}
+			cw.Write(record)
+		}
+		cw.Flush()
+		return cw.Error()
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, strings.ToUpper(strings.Join(fields, "\t")))
+		for _, entry := range entries {
+			record := make([]string, len(fields))
+			for i, f := range fields {
+				if f == "time" {
+					record[i] = entry.timestamp.Format("2006-01-02 15:04:05")
+				} else {
+					record[i] = tableCell(fieldString(entry, f))
+				}
+			}
+			fmt.Fprintln(tw, strings.Join(record, "\t"))
+		}
+		return tw.Flush()
+	default:
+		return fmt.Errorf("unknown format '%s'", format)
+	}
+	return nil
+}
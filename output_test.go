@@ -0,0 +1,46 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTableCell(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"ls -la", "ls -la"},
+		{"printf 'a\tb'", `printf 'a\tb'`},
+		{"for i in 1 2\ndo echo $i\ndone", `for i in 1 2\ndo echo $i\ndone`},
+		{"echo \r\x1b[31m", `echo \r\x1b[31m`},
+		{`find . -exec rm {} \;`, `find . -exec rm {} \;`},
+		{"echo über", "echo über"},
+	}
+	for _, test := range tests {
+		if got := tableCell(test.in); got != test.want {
+			t.Errorf("tableCell(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestPrintEntriesTable(t *testing.T) {
+	entries := []*HistoryEntry{
+		{cmd: "echo 'a\tb'\necho c", cwd: "/tmp", timestamp: time.Unix(0, 0)},
+		{cmd: "ls", cwd: "/home/me", timestamp: time.Unix(0, 0)},
+	}
+	var sb strings.Builder
+	if err := printEntries(&sb, "table", []string{"command", "workdir"}, entries); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(sb.String(), "\n"), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected a header and two rows, got %q", sb.String())
+	}
+	// The working directories are aligned in one column
+	col := strings.Index(lines[0], "WORKDIR")
+	if strings.Index(lines[1], "/tmp") != col || strings.Index(lines[2], "/home/me") != col {
+		t.Errorf("columns are not aligned:\n%s", sb.String())
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"container/list"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Frecency ranking of reverse-search results: every run of a command adds a
+// weight which decreases with its age, multiplied by how close the directory
+// it was run in is to the current one.
+
+const (
+	frecencyBoostSame   = 4.0 // run in the current directory
+	frecencyBoostParent = 2.0 // run in a parent of it, e.g. the repository root
+	frecencyBoostRepo   = 1.5 // run elsewhere in the same git repository
+	// Outside of repositories, only this many levels above the current
+	// directory count as its parents
+	frecencyParentLevels = 2
+)
+
+type rankedCommand struct {
+	cmd   string
+	score float64
+	entry *HistoryEntry // most recent occurrence
+}
+
+// frecencyDirs are the directories the ranking is relative to.
+type frecencyDirs struct {
+	workdir string
+	repo    string // root of the git repository workdir is in, if any
+	home    string
+}
+
+func newFrecencyDirs(workdir string) frecencyDirs {
+	home, _ := os.UserHomeDir()
+	return frecencyDirs{workdir: workdir, repo: gitRoot(workdir), home: home}
+}
+
+func frecencyWeight(age time.Duration) float64 {
+	switch {
+	case age < time.Hour:
+		return 16
+	case age < 24*time.Hour:
+		return 8
+	case age < 7*24*time.Hour:
+		return 4
+	case age < 30*24*time.Hour:
+		return 2
+	}
+	return 1
+}
+
+// gitRoot returns the top level directory of the git repository dir is in, or
+// an empty string.
+func gitRoot(dir string) string {
+	for {
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// isWithin reports whether path is dir or below it.
+func isWithin(path string, dir string) bool {
+	return path == dir || strings.HasPrefix(path, strings.TrimSuffix(dir, "/")+"/")
+}
+
+// isParent reports whether cwd is a parent of the current directory which is
+// specific to it: within its repository or, outside of repositories, a few
+// levels above it. / and the home directory contain almost everything.
+func (d frecencyDirs) isParent(cwd string) bool {
+	if !isWithin(d.workdir, cwd) || cwd == "/" || cwd == d.home {
+		return false
+	}
+	if d.repo != "" {
+		return isWithin(cwd, d.repo)
+	}
+	return strings.Count(strings.TrimPrefix(d.workdir, cwd), "/") <= frecencyParentLevels
+}
+
+// directoryBoost rates how related cwd, where a command was run, is to the
+// current directory.
+func (d frecencyDirs) directoryBoost(cwd string) float64 {
+	switch {
+	case cwd == "":
+		return 1
+	case cwd == d.workdir:
+		return frecencyBoostSame
+	case d.isParent(cwd):
+		return frecencyBoostParent
+	case d.repo != "" && isWithin(cwd, d.repo):
+		return frecencyBoostRepo
+	}
+	return 1
+}
+
+// rankFrecency returns the distinct commands in results, the most relevant
+// first. Commands with the same score are ordered by their most recent run.
+func rankFrecency(results list.List, dirs frecencyDirs, now time.Time) []rankedCommand {
+	byCmd := make(map[string]*rankedCommand)
+	var ranked []*rankedCommand
+
+	for e := results.Front(); e != nil; e = e.Next() {
+		entry, ok := e.Value.(*HistoryEntry)
+		if !ok {
+			log.Panic("Failed to retrieve entries")
+		}
+		c, seen := byCmd[entry.cmd]
+		if !seen {
+			c = &rankedCommand{cmd: entry.cmd, entry: entry}
+			byCmd[entry.cmd] = c
+			ranked = append(ranked, c)
+		} else if entry.timestamp.After(c.entry.timestamp) {
+			c.entry = entry
+		}
+		c.score += frecencyWeight(now.Sub(entry.timestamp)) * dirs.directoryBoost(entry.cwd)
+	}
+
+	result := make([]rankedCommand, 0, len(ranked))
+	for _, c := range ranked {
+		result = append(result, *c)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].score != result[j].score {
+			return result[i].score > result[j].score
+		}
+		return result[i].entry.timestamp.After(result[j].entry.timestamp)
+	})
+	return result
+}
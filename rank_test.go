@@ -0,0 +1,108 @@
+package main
+
+import (
+	"container/list"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGitRoot(t *testing.T) {
+	base := t.TempDir()
+	repo := filepath.Join(base, "repo")
+	sub := filepath.Join(repo, "a", "b")
+	other := filepath.Join(base, "other")
+	for _, dir := range []string{filepath.Join(repo, ".git"), sub, other} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		dir  string
+		want string
+	}{
+		{repo, repo},
+		{sub, repo},
+		{other, ""},
+	}
+	for _, test := range tests {
+		if got := gitRoot(test.dir); got != test.want {
+			t.Errorf("gitRoot(%s) = %q, want %q", test.dir, got, test.want)
+		}
+	}
+}
+
+func TestDirectoryBoost(t *testing.T) {
+	inRepo := frecencyDirs{workdir: "/home/me/src/project/cmd/tool", repo: "/home/me/src/project", home: "/home/me"}
+	noRepo := frecencyDirs{workdir: "/home/me/notes/2024/january", home: "/home/me"}
+
+	tests := []struct {
+		name string
+		dirs frecencyDirs
+		cwd  string
+		want float64
+	}{
+		{"same directory", inRepo, "/home/me/src/project/cmd/tool", frecencyBoostSame},
+		{"parent in repository", inRepo, "/home/me/src/project/cmd", frecencyBoostParent},
+		{"repository root", inRepo, "/home/me/src/project", frecencyBoostParent},
+		{"repository sibling", inRepo, "/home/me/src/project/docs", frecencyBoostRepo},
+		{"below current directory", inRepo, "/home/me/src/project/cmd/tool/testdata", frecencyBoostRepo},
+		{"parent outside repository", inRepo, "/home/me/src", 1},
+		{"home", inRepo, "/home/me", 1},
+		{"root", inRepo, "/", 1},
+		{"unrelated", inRepo, "/var/log", 1},
+		{"prefix of the name only", inRepo, "/home/me/src/proj", 1},
+		{"unknown", inRepo, "", 1},
+
+		{"same directory without repository", noRepo, "/home/me/notes/2024/january", frecencyBoostSame},
+		{"parent without repository", noRepo, "/home/me/notes/2024", frecencyBoostParent},
+		{"two levels up without repository", noRepo, "/home/me/notes", frecencyBoostParent},
+		{"home without repository", noRepo, "/home/me", 1},
+		{"root without repository", noRepo, "/", 1},
+		{"sibling without repository", noRepo, "/home/me/notes/2024/february", 1},
+	}
+	for _, test := range tests {
+		if got := test.dirs.directoryBoost(test.cwd); got != test.want {
+			t.Errorf("%s: directoryBoost(%s) = %v, want %v", test.name, test.cwd, got, test.want)
+		}
+	}
+}
+
+func TestRankFrecency(t *testing.T) {
+	now := time.Now()
+	dirs := frecencyDirs{workdir: "/src/project/cmd", repo: "/src/project", home: "/home/me"}
+	var results list.List
+	for _, entry := range []HistoryEntry{
+		// Run most recently, but elsewhere
+		{cmd: "make clean", cwd: "/tmp", timestamp: now.Add(-time.Minute)},
+		// Run here
+		{cmd: "make test", cwd: "/src/project/cmd", timestamp: now.Add(-2 * time.Minute)},
+		// Run often at the repository root
+		{cmd: "make", cwd: "/src/project", timestamp: now.Add(-3 * time.Minute)},
+		{cmd: "make", cwd: "/src/project", timestamp: now.Add(-4 * time.Minute)},
+		{cmd: "make", cwd: "/src/project", timestamp: now.Add(-5 * time.Minute)},
+		// Same score as "make clean", which has been run more recently
+		{cmd: "make lint", cwd: "/var/tmp", timestamp: now.Add(-10 * time.Minute)},
+		// Old runs count less
+		{cmd: "make install", cwd: "/src/project/cmd", timestamp: now.Add(-60 * 24 * time.Hour)},
+	} {
+		entry := entry
+		results.PushBack(&entry)
+	}
+
+	ranked := rankFrecency(results, dirs, now)
+	var cmds []string
+	for _, c := range ranked {
+		cmds = append(cmds, c.cmd)
+	}
+	want := []string{"make", "make test", "make clean", "make lint", "make install"}
+	if !reflect.DeepEqual(cmds, want) {
+		t.Fatalf("rankFrecency = %v, want %v", cmds, want)
+	}
+	if !ranked[0].entry.timestamp.Equal(now.Add(-3 * time.Minute)) {
+		t.Errorf("rankFrecency: 'make' refers to the run at %v, want the most recent one", ranked[0].entry.timestamp)
+	}
+}
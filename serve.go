@@ -0,0 +1,388 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// serve provides search and stats over HTTP, see "hs9001 serve". All
+// endpoints take the filters of the search subcommand as query parameters,
+// e.g. /api/search?q=docker&cwd=/home/me&ret=0&limit=20. The web UI in
+// web/ is built on top of the API.
+
+//go:embed web
+var webFiles embed.FS
+
+const serveMaxLimit = 10000
+
+type server struct {
+	conn        *sql.DB
+	token       string // required in requests if not empty
+	allowDelete bool
+	unix        bool   // listening on a Unix socket, which browsers cannot connect to
+	listenHost  string // host name the server has been started with, may be empty
+}
+
+// Query parameters which are not filter flags
+var serveParameters = map[string]bool{
+	"q": true, "limit": true, "offset": true, "order": true, "fields": true,
+	"top": true, "min-runs": true, "token": true,
+}
+
+// filterFromQuery parses the filter flags given as query parameters.
+func filterFromQuery(conn *sql.DB, values url.Values) (searchopts, error) {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	filter := addFilterFlags(flags)
+	for key, vals := range values {
+		if serveParameters[key] {
+			continue
+		}
+		if flags.Lookup(key) == nil || key == "this-session" {
+			return searchopts{}, fmt.Errorf("unknown parameter '%s'", key)
+		}
+		for _, v := range vals {
+			if err := flags.Set(key, v); err != nil {
+				return searchopts{}, fmt.Errorf("invalid value for '%s': %s", key, err.Error())
+			}
+		}
+	}
+	return filter.parse(conn, values.Get("q"))
+}
+
+// intParameter returns the value of an integer query parameter in the range
+// [min, max], or def if it is not given.
+func intParameter(values url.Values, key string, def int, min int, max int) (int, error) {
+	v := values.Get(key)
+	if v == "" {
+		return def, nil
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil || i < min || i > max {
+		return 0, fmt.Errorf("'%s' must be a number between %d and %d", key, min, max)
+	}
+	return i, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	data, err := marshalJSON(v)
+	if err != nil {
+		log.Panic(err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+	w.Write([]byte("\n"))
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// authorized checks the token, given either as bearer token or as parameter.
+func (s *server) authorized(r *http.Request) bool {
+	if s.token == "" {
+		return true
+	}
+	token := r.URL.Query().Get("token")
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token = strings.TrimPrefix(auth, "Bearer ")
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) == 1
+}
+
+// allowedHost rejects requests for other host names, which web sites can make
+// by pointing their own name to the loopback address (DNS rebinding). IP
+// addresses and localhost cannot be taken over this way.
+func (s *server) allowedHost(r *http.Request) bool {
+	if s.unix {
+		return true
+	}
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	// Without a host name, e.g. ":9001" or "0.0.0.0:9001", only addresses work
+	return net.ParseIP(host) != nil || strings.EqualFold(host, "localhost") || (s.listenHost != "" && strings.EqualFold(host, s.listenHost))
+}
+
+// sameOrigin rejects requests which other web sites make the browser send.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+// handler wraps the API endpoints, which only answer requests with the given
+// method.
+func (s *server) handler(method string, f func(w http.ResponseWriter, r *http.Request)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+			return
+		}
+		if !s.authorized(r) {
+			writeError(w, http.StatusUnauthorized, fmt.Errorf("invalid token"))
+			return
+		}
+		if method != http.MethodGet && !sameOrigin(r) {
+			writeError(w, http.StatusForbidden, fmt.Errorf("cross-origin request"))
+			return
+		}
+		f(w, r)
+	}
+}
+
+type searchResponse struct {
+	Entries []json.RawMessage `json:"entries"`
+	Offset  int               `json:"offset"`
+	Limit   int               `json:"limit"`
+	More    bool              `json:"more"` // whether there are more entries after these
+}
+
+func (s *server) search(w http.ResponseWriter, r *http.Request) {
+	values := r.URL.Query()
+	opts, err := filterFromQuery(s.conn, values)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	fields, err := parseFields(values.Get("fields"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	limit, err := intParameter(values, "limit", 100, 1, serveMaxLimit)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	offset, err := intParameter(values, "offset", 0, 0, int(^uint(0)>>1))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	order := "DESC"
+	switch values.Get("order") {
+	case "", "desc":
+	case "asc":
+		order = "ASC"
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Errorf("'order' must be either asc or desc"))
+		return
+	}
+
+	// One more entry than requested tells whether there are more
+	fetch := limit + 1
+	opts.limit = &fetch
+	opts.offset = &offset
+	opts.order = &order
+
+	resp := searchResponse{Entries: []json.RawMessage{}, Offset: offset, Limit: limit}
+	results := search(s.conn, opts)
+	for e := results.Front(); e != nil; e = e.Next() {
+		entry, ok := e.Value.(*HistoryEntry)
+		if !ok {
+			log.Panic("Failed to retrieve entries")
+		}
+		if len(resp.Entries) == limit {
+			resp.More = true
+			break
+		}
+		obj, err := jsonObject(entry, fields)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		resp.Entries = append(resp.Entries, obj)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *server) stats(w http.ResponseWriter, r *http.Request) {
+	values := r.URL.Query()
+	opts, err := filterFromQuery(s.conn, values)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	top, err := intParameter(values, "top", 10, 0, serveMaxLimit)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	minRuns, err := intParameter(values, "min-runs", 5, 0, int(^uint(0)>>1))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	st := computeStats(search(s.conn, opts))
+	writeJSON(w, http.StatusOK, st.json(top, minRuns))
+}
+
+type sessionJSON struct {
+	Id       string    `json:"id"`
+	Hostname string    `json:"hostname"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Count    int       `json:"count"`
+}
+
+func (s *server) sessions(w http.ResponseWriter, r *http.Request) {
+	result := []sessionJSON{}
+	for _, info := range sessions(s.conn) {
+		result = append(result, sessionJSON{Id: info.id, Hostname: info.hostname, Start: info.start, End: info.end, Count: info.count})
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// deleteEntry deletes the entry with the uuid given as parameter, if the
+// server has been started with -allow-delete.
+func (s *server) deleteEntry(w http.ResponseWriter, r *http.Request) {
+	if !s.allowDelete {
+		writeError(w, http.StatusForbidden, fmt.Errorf("deleting entries is not allowed, see 'serve -allow-delete'"))
+		return
+	}
+	uuid := r.URL.Query().Get("uuid")
+	if uuid == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("missing 'uuid'"))
+		return
+	}
+	results := search(s.conn, searchopts{uuid: &uuid})
+	if results.Len() == 0 {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no entry with uuid %s", uuid))
+		return
+	}
+	entry, ok := results.Front().Value.(*HistoryEntry)
+	if !ok {
+		log.Panic("Failed to retrieve entries")
+	}
+	delete(s.conn, entry.id)
+	writeJSON(w, http.StatusOK, map[string]string{"deleted": uuid})
+}
+
+type infoResponse struct {
+	AllowDelete bool     `json:"allow_delete"`
+	Encrypted   bool     `json:"encrypted"`
+	Hosts       []string `json:"hosts"`
+}
+
+// info describes the server and the database, for the web UI.
+func (s *server) info(w http.ResponseWriter, r *http.Request) {
+	resp := infoResponse{
+		AllowDelete: s.allowDelete,
+		Encrypted:   encryption != nil,
+		Hosts:       []string{},
+	}
+	// Host names are not encrypted, most used first
+	rows, err := s.conn.Query("SELECT hostname FROM history GROUP BY hostname ORDER BY COUNT(id) DESC")
+	if err != nil {
+		log.Panic(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var host string
+		err = rows.Scan(&host)
+		if err != nil {
+			log.Panic(err)
+		}
+		resp.Hosts = append(resp.Hosts, host)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *server) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/search", s.handler(http.MethodGet, s.search))
+	mux.HandleFunc("/api/stats", s.handler(http.MethodGet, s.stats))
+	mux.HandleFunc("/api/sessions", s.handler(http.MethodGet, s.sessions))
+	mux.HandleFunc("/api/info", s.handler(http.MethodGet, s.info))
+	mux.HandleFunc("/api/entry", s.handler(http.MethodDelete, s.deleteEntry))
+
+	// The UI itself contains no data, it asks for the token if required
+	web, err := fs.Sub(webFiles, "web")
+	if err != nil {
+		log.Panic(err)
+	}
+	mux.Handle("/", http.FileServer(http.FS(web)))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.allowedHost(r) {
+			writeError(w, http.StatusMisdirectedRequest, fmt.Errorf("unknown host '%s'", r.Host))
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// listen opens address, which is either host:port or unix:<path>.
+func listen(address string) (net.Listener, error) {
+	if strings.HasPrefix(address, "unix:") {
+		return listenUnix(strings.TrimPrefix(address, "unix:"))
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		log.Printf("Warning: %s is reachable from other machines", address)
+	}
+	return net.Listen("tcp", address)
+}
+
+// runServer serves the API and the web UI on address. Over TCP, any web site
+// the user visits can send requests to the server, so a random token is
+// required unless one is given.
+func runServer(conn *sql.DB, address string, token string, allowDelete bool) error {
+	listener, err := listen(address)
+	if err != nil {
+		return err
+	}
+	s := &server{conn: conn, token: token, allowDelete: allowDelete}
+	if strings.HasPrefix(address, "unix:") {
+		s.unix = true
+		log.Printf("Listening on %s", listener.Addr().String())
+		return http.Serve(listener, s.routes())
+	}
+
+	s.listenHost, _, err = net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	host := s.listenHost
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "localhost"
+	}
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	url := "http://" + net.JoinHostPort(host, port) + "/"
+	if s.token == "" {
+		buf := make([]byte, 16)
+		_, err = rand.Read(buf)
+		if err != nil {
+			return err
+		}
+		s.token = hex.EncodeToString(buf)
+		url += "#token=" + s.token
+	}
+	log.Printf("Listening on %s, open %s", listener.Addr().String(), url)
+	return http.Serve(listener, s.routes())
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAllowedHost(t *testing.T) {
+	tests := []struct {
+		unix       bool
+		listenHost string
+		host       string
+		allowed    bool
+	}{
+		{false, "127.0.0.1", "127.0.0.1:9001", true},
+		{false, "127.0.0.1", "localhost:9001", true},
+		{false, "127.0.0.1", "LOCALHOST:9001", true},
+		{false, "127.0.0.1", "[::1]:9001", true},
+		{false, "127.0.0.1", "192.168.1.5:9001", true},
+		{false, "127.0.0.1", "localhost", true},
+		{false, "127.0.0.1", "evil.example:9001", false},
+		{false, "127.0.0.1", "localhost.evil.example:9001", false},
+		{false, "myhost.lan", "myhost.lan:9001", true},
+		{false, "myhost.lan", "evil.example:9001", false},
+		// Listening on all addresses, e.g. -listen :9001
+		{false, "", "127.0.0.1:9001", true},
+		{false, "", "192.168.1.5:9001", true},
+		{false, "", "evil.example:9001", false},
+		{false, "0.0.0.0", "evil.example:9001", false},
+		{false, "::", "[::1]:9001", true},
+		// Unix socket
+		{true, "", "evil.example", true},
+	}
+	for _, test := range tests {
+		s := &server{unix: test.unix, listenHost: test.listenHost}
+		r := httptest.NewRequest("GET", "/api/info", nil)
+		r.Host = test.host
+		if got := s.allowedHost(r); got != test.allowed {
+			t.Errorf("allowedHost(%s) listening on %q = %v, want %v", test.host, test.listenHost, got, test.allowed)
+		}
+	}
+}
+
+func TestSearchInvalidMatch(t *testing.T) {
+	conn := newTestDatabase(t, "db.sqlite")
+	add(conn, NewHistoryEntry("git push", 0))
+	s := &server{conn: conn, listenHost: "127.0.0.1"}
+
+	r := httptest.NewRequest("GET", "/api/search?match=AND(", nil)
+	r.Host = "localhost:9001"
+	w := httptest.NewRecorder()
+	s.routes().ServeHTTP(w, r)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	var resp map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp["error"] == "" {
+		t.Errorf("response %q is not a JSON error (%v)", w.Body.String(), err)
+	}
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+type counter struct {
+	key   string
+	count int
+}
+
+// countMap counts occurrences of keys.
+type countMap map[string]int
+
+// top returns the n keys with the highest counts, ordered by count.
+func (m countMap) top(n int) []counter {
+	var counters []counter
+	for k, c := range m {
+		counters = append(counters, counter{key: k, count: c})
+	}
+	sort.Slice(counters, func(i, j int) bool {
+		if counters[i].count == counters[j].count {
+			return counters[i].key < counters[j].key
+		}
+		return counters[i].count > counters[j].count
+	})
+	if n > 0 && len(counters) > n {
+		counters = counters[:n]
+	}
+	return counters
+}
+
+type failureStats struct {
+	runs   int
+	failed int
+}
+
+type hostStats struct {
+	count int
+	first time.Time
+	last  time.Time
+}
+
+type stats struct {
+	total    int
+	withTime int
+	known    int // entries with a known exit code
+	failed   int
+	first    time.Time
+	last     time.Time
+	commands countMap
+	programs countMap
+	workdirs countMap
+	days     countMap
+	heatmap  [7][24]int
+	failures map[string]*failureStats
+	hosts    map[string]*hostStats
+}
+
+// programName returns the program a command line runs, skipping leading
+// environment variable assignments and sudo.
+func programName(cmd string) string {
+	for _, field := range strings.Fields(cmd) {
+		if strings.Contains(field, "=") && !strings.HasPrefix(field, "=") {
+			continue
+		}
+		if field == "sudo" {
+			continue
+		}
+		return field
+	}
+	return ""
+}
+
+func computeStats(results list.List) stats {
+	s := stats{
+		commands: make(countMap),
+		programs: make(countMap),
+		workdirs: make(countMap),
+		days:     make(countMap),
+		failures: make(map[string]*failureStats),
+		hosts:    make(map[string]*hostStats),
+	}
+
+	for e := results.Front(); e != nil; e = e.Next() {
+		entry, ok := e.Value.(*HistoryEntry)
+		if !ok {
+			log.Panic("Failed to retrieve entries")
+		}
+		s.total++
+		s.commands[entry.cmd]++
+		program := programName(entry.cmd)
+		if program != "" {
+			s.programs[program]++
+		}
+		if entry.cwd != "" {
+			s.workdirs[entry.cwd]++
+		}
+
+		if entry.retval != -9001 && program != "" {
+			s.known++
+			f, ok := s.failures[program]
+			if !ok {
+				f = &failureStats{}
+				s.failures[program] = f
+			}
+			f.runs++
+			if entry.retval != 0 {
+				f.failed++
+				s.failed++
+			}
+		}
+
+		h, ok := s.hosts[entry.hostname]
+		if !ok {
+			h = &hostStats{}
+			s.hosts[entry.hostname] = h
+		}
+		h.count++
+
+		// Imported entries without a timestamp are left out of all time based statistics
+		if entry.timestamp.Unix() <= 0 {
+			continue
+		}
+		s.withTime++
+		t := entry.timestamp.Local()
+		if s.first.IsZero() || t.Before(s.first) {
+			s.first = t
+		}
+		if t.After(s.last) {
+			s.last = t
+		}
+		if h.first.IsZero() || t.Before(h.first) {
+			h.first = t
+		}
+		if t.After(h.last) {
+			h.last = t
+		}
+		s.days[t.Format("2006-01-02")]++
+		s.heatmap[t.Weekday()][t.Hour()]++
+	}
+	return s
+}
+
+func printCounters(title string, counters []counter) {
+	fmt.Printf("\n%s\n", title)
+	for _, c := range counters {
+		fmt.Printf("%8d  %s\n", c.count, c.key)
+	}
+}
+
+func percentage(part int, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(part) / float64(total)
+}
+
+func (s *stats) printHeatmap() {
+	shades := []rune(" ░▒▓█")
+	max := 0
+	for _, day := range s.heatmap {
+		for _, c := range day {
+			if c > max {
+				max = c
+			}
+		}
+	}
+
+	fmt.Printf("\nCommands per weekday and hour\n")
+	fmt.Printf("     0     3     6     9     12    15    18    21\n")
+	// Start the week on Monday
+	for i := 1; i <= 7; i++ {
+		day := time.Weekday(i % 7)
+		var sb strings.Builder
+		for _, c := range s.heatmap[day] {
+			shade := shades[0]
+			if c > 0 && max > 0 {
+				shade = shades[1+(c*(len(shades)-2))/max]
+			}
+			sb.WriteRune(shade)
+			sb.WriteRune(shade)
+		}
+		fmt.Printf("%s  %s\n", day.String()[:3], sb.String())
+	}
+}
+
+// failingPrograms returns the top programs with the highest failure rate
+// which have been run at least minRuns times.
+func (s *stats) failingPrograms(top int, minRuns int) []string {
+	var programs []string
+	for program, f := range s.failures {
+		if f.runs >= minRuns && f.failed > 0 {
+			programs = append(programs, program)
+		}
+	}
+	sort.Slice(programs, func(i, j int) bool {
+		fi, fj := s.failures[programs[i]], s.failures[programs[j]]
+		ri, rj := percentage(fi.failed, fi.runs), percentage(fj.failed, fj.runs)
+		if ri == rj {
+			return fi.runs > fj.runs
+		}
+		return ri > rj
+	})
+	if top > 0 && len(programs) > top {
+		programs = programs[:top]
+	}
+	return programs
+}
+
+func (s *stats) hostsByCount() []string {
+	var hosts []string
+	for host := range s.hosts {
+		hosts = append(hosts, host)
+	}
+	sort.Slice(hosts, func(i, j int) bool {
+		return s.hosts[hosts[i]].count > s.hosts[hosts[j]].count
+	})
+	return hosts
+}
+
+func (s *stats) print(top int, minRuns int) {
+	fmt.Printf("Commands: %d (%d distinct)\n", s.total, len(s.commands))
+	if s.known > 0 {
+		fmt.Printf("Failed:   %d of %d with known exit code (%.1f%%)\n", s.failed, s.known, percentage(s.failed, s.known))
+	}
+	if s.withTime > 0 {
+		fmt.Printf("Period:   %s - %s\n", s.first.Format("2006-01-02 15:04"), s.last.Format("2006-01-02 15:04"))
+	}
+
+	printCounters("Most used commands", s.commands.top(top))
+	printCounters("Most used programs", s.programs.top(top))
+	printCounters("Busiest directories", s.workdirs.top(top))
+	printCounters("Busiest days", s.days.top(top))
+
+	if s.withTime > 0 {
+		s.printHeatmap()
+	}
+
+	fmt.Printf("\nFailure rate by program (at least %d runs)\n", minRuns)
+	for _, program := range s.failingPrograms(top, minRuns) {
+		f := s.failures[program]
+		fmt.Printf("%7.1f%%  %d/%d  %s\n", percentage(f.failed, f.runs), f.failed, f.runs, program)
+	}
+
+	fmt.Printf("\nHosts\n")
+	for _, host := range s.hostsByCount() {
+		h := s.hosts[host]
+		period := ""
+		if !h.first.IsZero() {
+			period = h.first.Format("2006-01-02") + " - " + h.last.Format("2006-01-02")
+		}
+		fmt.Printf("%8d  %-24s %s\n", h.count, host, period)
+	}
+}
+
+type countJSON struct {
+	Key   string `json:"key"`
+	Count int    `json:"count"`
+}
+
+type failureJSON struct {
+	Program string `json:"program"`
+	Runs    int    `json:"runs"`
+	Failed  int    `json:"failed"`
+}
+
+type hostJSON struct {
+	Hostname string     `json:"hostname"`
+	Count    int        `json:"count"`
+	First    *time.Time `json:"first"`
+	Last     *time.Time `json:"last"`
+}
+
+// statsJSON is the representation of stats served by "hs9001 serve".
+type statsJSON struct {
+	Total    int           `json:"total"`
+	Distinct int           `json:"distinct"`
+	Known    int           `json:"known"`
+	Failed   int           `json:"failed"`
+	First    *time.Time    `json:"first"`
+	Last     *time.Time    `json:"last"`
+	Commands []countJSON   `json:"commands"`
+	Programs []countJSON   `json:"programs"`
+	Workdirs []countJSON   `json:"workdirs"`
+	Days     []countJSON   `json:"days"`
+	Heatmap  [7][24]int    `json:"heatmap"` // by weekday (0 = Sunday) and hour
+	Failures []failureJSON `json:"failures"`
+	Hosts    []hostJSON    `json:"hosts"`
+}
+
+func countsJSON(counters []counter) []countJSON {
+	result := []countJSON{}
+	for _, c := range counters {
+		result = append(result, countJSON{Key: c.key, Count: c.count})
+	}
+	return result
+}
+
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func (s *stats) json(top int, minRuns int) statsJSON {
+	result := statsJSON{
+		Total:    s.total,
+		Distinct: len(s.commands),
+		Known:    s.known,
+		Failed:   s.failed,
+		First:    optionalTime(s.first),
+		Last:     optionalTime(s.last),
+		Commands: countsJSON(s.commands.top(top)),
+		Programs: countsJSON(s.programs.top(top)),
+		Workdirs: countsJSON(s.workdirs.top(top)),
+		Days:     countsJSON(s.days.top(top)),
+		Heatmap:  s.heatmap,
+		Failures: []failureJSON{},
+		Hosts:    []hostJSON{},
+	}
+	for _, program := range s.failingPrograms(top, minRuns) {
+		f := s.failures[program]
+		result.Failures = append(result.Failures, failureJSON{Program: program, Runs: f.runs, Failed: f.failed})
+	}
+	for _, host := range s.hostsByCount() {
+		h := s.hosts[host]
+		result.Hosts = append(result.Hosts, hostJSON{Hostname: host, Count: h.count, First: optionalTime(h.first), Last: optionalTime(h.last)})
+	}
+	return result
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+)
+
+// syncKey identifies entries which were recorded before they had a UUID, e.g.
+// the same history file imported into both databases.
+type syncKey struct {
+	cmd       string
+	timestamp int64
+	hostname  string
+}
+
+func syncEntries(conn *sql.DB) []HistoryEntry {
+	rows, err := conn.Query("SELECT uuid, user, command, hostname, workdir, timestamp, retval, duration, session, source FROM history ORDER BY id ASC")
+	if err != nil {
+		log.Panic(err)
+	}
+	defer rows.Close()
+
+	var result []HistoryEntry
+	for rows.Next() {
+		var entry HistoryEntry
+		var timestamp int64
+		err = rows.Scan(&entry.uuid, &entry.user, &entry.cmd, &entry.hostname, &entry.cwd, &timestamp, &entry.retval, &entry.duration, &entry.session, &entry.source)
+		if err != nil {
+			log.Panic(err)
+		}
+		entry.cmd = openColumn(entry.cmd)
+		entry.cwd = openColumn(entry.cwd)
+		entry.timestamp = time.Unix(timestamp, 0)
+		result = append(result, entry)
+	}
+	return result
+}
+
+func countEntries(conn *sql.DB) int {
+	var count int
+	err := conn.QueryRow("SELECT COUNT(id) FROM history").Scan(&count)
+	if err != nil {
+		log.Panic(err)
+	}
+	return count
+}
+
+// copyMissing adds the entries of from which are not among the existing
+// entries of conn. It returns the number of entries copied.
+//
+// Entries are the same if they have the same UUID. Otherwise, entries with the
+// same key are counted: e.g. a command imported without a timestamp five times
+// into one database and three times into the other is copied twice.
+func copyMissing(conn *sql.DB, existing []HistoryEntry, from []HistoryEntry) int {
+	uuids := make(map[string]bool)
+	counts := make(map[syncKey]int)
+	for _, entry := range existing {
+		uuids[entry.uuid] = true
+		counts[syncKey{entry.cmd, entry.timestamp.Unix(), entry.hostname}]++
+	}
+	// Entries with a known UUID do not stand in for others
+	for _, entry := range from {
+		if uuids[entry.uuid] {
+			counts[syncKey{entry.cmd, entry.timestamp.Unix(), entry.hostname}]--
+		}
+	}
+
+	_, err := conn.Exec("BEGIN;")
+	if err != nil {
+		log.Panic(err)
+	}
+	stmt := prepareAdd(conn)
+	defer stmt.Close()
+
+	copied := 0
+	for _, entry := range from {
+		if uuids[entry.uuid] {
+			continue
+		}
+		key := syncKey{entry.cmd, entry.timestamp.Unix(), entry.hostname}
+		if counts[key] > 0 {
+			counts[key]--
+			continue
+		}
+		if entry.source == "live" {
+			entry.source = "sync:" + entry.hostname
+		}
+		addPrepared(stmt, entry)
+		copied++
+	}
+
+	_, err = conn.Exec("END;")
+	if err != nil {
+		log.Panic(err)
+	}
+	return copied
+}
+
+// encryptionSettings are copied to an empty database, so it is encrypted with
+// the same key.
+var encryptionSettings = []string{"key_source", "key_check", "kdf_salt", "kdf_iterations"}
+
+// syncDatabases merges the histories of both databases, so that afterwards
+// both contain every entry once. It returns the number of entries copied in
+// each direction. Both databases have to use the same key if they are
+// encrypted.
+func syncDatabases(local *sql.DB, remote *sql.DB) (pulled int, pushed int, err error) {
+	// The key check values are equal if the keys are. This has to be checked
+	// before any entry of remote is decrypted.
+	if getSetting(local, "key_check") != getSetting(remote, "key_check") {
+		if getSetting(remote, "key_check") != "" || countEntries(remote) > 0 {
+			return 0, 0, errors.New("the databases are not encrypted with the same key")
+		}
+		for _, key := range encryptionSettings {
+			setSetting(remote, key, getSetting(local, key))
+		}
+	}
+
+	localEntries := syncEntries(local)
+	remoteEntries := syncEntries(remote)
+
+	pulled = copyMissing(local, localEntries, remoteEntries)
+	pushed = copyMissing(remote, remoteEntries, localEntries)
+	return pulled, pushed, nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestDatabase(t *testing.T, name string) *sql.DB {
+	conn := openDatabase(filepath.Join(t.TempDir(), name))
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// addImported adds cmd n times as if it had been imported without timestamp.
+func addImported(conn *sql.DB, cmd string, n int) {
+	for i := 0; i < n; i++ {
+		entry := NewHistoryEntry(cmd, -9001)
+		entry.hostname = "host"
+		entry.timestamp = time.Unix(0, 0)
+		entry.source = "bash_history"
+		add(conn, entry)
+	}
+}
+
+func countCommand(conn *sql.DB, cmd string) int {
+	results := search(conn, searchopts{command: &cmd})
+	return results.Len()
+}
+
+func TestSyncDatabases(t *testing.T) {
+	a := newTestDatabase(t, "a.sqlite")
+	b := newTestDatabase(t, "b.sqlite")
+
+	// The same file imported into both
+	addImported(a, "make", 2)
+	addImported(b, "make", 2)
+	// Imported more often into a
+	addImported(a, "ls", 5)
+	addImported(b, "ls", 3)
+	// Recorded on only one side
+	add(a, NewHistoryEntry("git push", 0))
+	add(b, NewHistoryEntry("git pull", 0))
+
+	pulled, pushed, err := syncDatabases(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pulled != 1 || pushed != 3 {
+		t.Errorf("first sync copied %d and %d entries, want 1 and 3", pulled, pushed)
+	}
+	for _, conn := range []*sql.DB{a, b} {
+		for cmd, want := range map[string]int{"make": 2, "ls": 5, "git push": 1, "git pull": 1} {
+			if got := countCommand(conn, cmd); got != want {
+				t.Errorf("%q is %d times in the database after the sync, want %d", cmd, got, want)
+			}
+		}
+	}
+
+	pulled, pushed, err = syncDatabases(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pulled != 0 || pushed != 0 {
+		t.Errorf("second sync copied %d and %d entries, want none", pulled, pushed)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// TemplateEntry is the view of a HistoryEntry which templates passed to
+// search -template operate on.
+type TemplateEntry struct {
+	Id       uint32
+	Time     time.Time
+	Cmd      string
+	Cwd      string
+	Hostname string
+	User     string
+	Retval   int
+	Duration time.Duration // negative if unknown
+	Session  string
+	Source   string
+	Uuid     string
+}
+
+func newTemplateEntry(entry *HistoryEntry) TemplateEntry {
+	return TemplateEntry{
+		Id:       entry.id,
+		Time:     entry.timestamp,
+		Cmd:      entry.cmd,
+		Cwd:      entry.cwd,
+		Hostname: entry.hostname,
+		User:     entry.user,
+		Retval:   entry.retval,
+		Duration: time.Duration(entry.duration) * time.Second,
+		Session:  entry.session,
+		Source:   entry.source,
+		Uuid:     entry.uuid,
+	}
+}
+
+func relativeTime(t time.Time) string {
+	if t.Unix() <= 0 {
+		return "unknown"
+	}
+	age := time.Since(t)
+	switch {
+	case age < time.Minute:
+		return "just now"
+	case age < time.Hour:
+		return fmt.Sprintf("%dm ago", int(age/time.Minute))
+	case age < 24*time.Hour:
+		return fmt.Sprintf("%dh ago", int(age/time.Hour))
+	case age < 365*24*time.Hour:
+		return fmt.Sprintf("%dd ago", int(age/(24*time.Hour)))
+	}
+	return fmt.Sprintf("%dy ago", int(age/(365*24*time.Hour)))
+}
+
+// shortenPath replaces the home directory with ~ and abbreviates all but
+// the last path element to their first character, like fish's prompt_pwd.
+func shortenPath(path string) string {
+	home := os.Getenv("HOME")
+	if home != "" && (path == home || strings.HasPrefix(path, home+"/")) {
+		path = "~" + strings.TrimPrefix(path, home)
+	}
+	elems := strings.Split(path, "/")
+	for i := 0; i < len(elems)-1; i++ {
+		r := []rune(elems[i])
+		if len(r) > 1 {
+			if r[0] == '.' && len(r) > 2 {
+				elems[i] = string(r[:2])
+			} else {
+				elems[i] = string(r[:1])
+			}
+		}
+	}
+	return strings.Join(elems, "/")
+}
+
+func templateFuncs(printColors bool) template.FuncMap {
+	return template.FuncMap{
+		"ago":       relativeTime,
+		"shortpath": shortenPath,
+		"basename":  filepath.Base,
+		"duration": func(d time.Duration) string {
+			if d < 0 {
+				return "-"
+			}
+			return d.String()
+		},
+		// statuscolor prints text in the color for failed commands if retval is not 0
+		"statuscolor": func(retval int, text string) string {
+			if !printColors || retval == 0 {
+				return text
+			}
+			return "\033[" + config.failedColor + "m" + text + "\033[0m"
+		},
+	}
+}
+
+// parseOutputTemplate parses a template which is executed for every entry.
+// A newline is appended to each entry's output.
+func parseOutputTemplate(text string, printColors bool) (*template.Template, error) {
+	return template.New("search").Funcs(templateFuncs(printColors)).Parse(text + "\n")
+}